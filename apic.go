@@ -2,7 +2,6 @@ package ini
 
 import (
 	"io"
-	"os"
 )
 
 func ini_insert_token(parser *ini_parser_t, pos int, token *ini_token_t) {
@@ -48,9 +47,9 @@ func ini_string_read_handler(parser *ini_parser_t, buffer []byte) (n int, err er
 	return n, nil
 }
 
-// File read handler.
-func ini_file_read_handler(parser *ini_parser_t, buffer []byte) (n int, err error) {
-	return parser.input_file.Read(buffer)
+// Reader read handler.
+func ini_reader_read_handler(parser *ini_parser_t, buffer []byte) (n int, err error) {
+	return parser.input_reader.Read(buffer)
 }
 
 // Set a string input.
@@ -63,13 +62,28 @@ func ini_parser_set_input_string(parser *ini_parser_t, input []byte) {
 	parser.input_pos = 0
 }
 
-// Set a file input.
-func ini_parser_set_input_file(parser *ini_parser_t, file *os.File) {
+// Set a reader input.
+func ini_parser_set_input_reader(parser *ini_parser_t, r io.Reader) {
 	if parser.read_handler != nil {
 		panic("must set the input source only once")
 	}
-	parser.read_handler = ini_file_read_handler
-	parser.input_file = file
+	parser.read_handler = ini_reader_read_handler
+	parser.input_reader = r
+}
+
+// Set if section names may hold any character but ']'.
+func ini_parser_set_permissive_names(parser *ini_parser_t, permissive bool) {
+	parser.permissive_names = permissive
+}
+
+// Set if the parser recovers from syntax errors.
+func ini_parser_set_tolerant(parser *ini_parser_t, tolerant bool) {
+	parser.tolerant = tolerant
+}
+
+// Set if values go on over continuation lines.
+func ini_parser_set_continuation(parser *ini_parser_t, continuation bool) {
+	parser.continuation = continuation
 }
 
 // Create a new emitter object.
@@ -94,9 +108,9 @@ func ini_string_write_handler(emitter *ini_emitter_t, buffer []byte) error {
 	return nil
 }
 
-// File write handler.
-func ini_file_write_handler(emitter *ini_emitter_t, buffer []byte) error {
-	_, err := emitter.output_file.Write(buffer)
+// Writer write handler.
+func ini_writer_write_handler(emitter *ini_emitter_t, buffer []byte) error {
+	_, err := emitter.output_writer.Write(buffer)
 	return err
 }
 
@@ -109,13 +123,13 @@ func ini_emitter_set_output_string(emitter *ini_emitter_t, output_buffer *[]byte
 	emitter.output_buffer = output_buffer
 }
 
-// Set a file output.
-func ini_emitter_set_output_file(emitter *ini_emitter_t, file io.Writer) {
+// Set a writer output.
+func ini_emitter_set_output_writer(emitter *ini_emitter_t, w io.Writer) {
 	if emitter.write_handler != nil {
 		panic("must set the output target only once")
 	}
-	emitter.write_handler = ini_file_write_handler
-	emitter.output_file = file
+	emitter.write_handler = ini_writer_write_handler
+	emitter.output_writer = w
 }
 
 // Set if unescaped non-ASCII characters are allowed.
@@ -123,6 +137,11 @@ func ini_emitter_set_unicode(emitter *ini_emitter_t, unicode bool) {
 	emitter.unicode = unicode
 }
 
+// Set if section names may hold any character but ']'.
+func ini_emitter_set_permissive_names(emitter *ini_emitter_t, permissive bool) {
+	emitter.permissive_names = permissive
+}
+
 // Set the preferred line break character.
 func ini_emitter_set_break(emitter *ini_emitter_t, line_break ini_break_t) {
 	emitter.line_break = line_break
@@ -147,9 +166,36 @@ func ini_document_end_event_initialize(event *ini_event_t) bool {
 // Create ELEMENT.
 func ini_scalar_event_initialize(event *ini_event_t, value []byte, style ini_scalar_style_t) bool {
 	*event = ini_event_t{
-		typ:     ini_SCALAR_EVENT,
-		value:   value,
-		style:   ini_style_t(style),
+		typ:   ini_SCALAR_EVENT,
+		value: value,
+		style: ini_style_t(style),
+	}
+	return true
+}
+
+// Create SECTION-ENTRY.
+func ini_section_entry_event_initialize(event *ini_event_t, value []byte) bool {
+	*event = ini_event_t{
+		typ:   ini_SECTION_ENTRY_EVENT,
+		value: value,
+		tag:   []byte(ini_SECTION_TAG),
+	}
+	return true
+}
+
+// Create SECTION-INHERIT.
+func ini_section_inherit_event_initialize(event *ini_event_t, value []byte) bool {
+	*event = ini_event_t{
+		typ:   ini_SECTION_INHERIT_EVENT,
+		value: value,
+	}
+	return true
+}
+
+// Create MAPPING.
+func ini_mapping_event_initialize(event *ini_event_t) bool {
+	*event = ini_event_t{
+		typ: ini_MAPPING_EVENT,
 	}
 	return true
 }
@@ -4,9 +4,11 @@ import (
 	"encoding"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"math"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,6 +18,7 @@ const (
 	sectionNode
 	mappingNode
 	scalarNode
+	sequenceNode
 	commentNode
 )
 
@@ -25,15 +28,47 @@ type node struct {
 	tag          string
 	value        string
 	children     []*node
+
+	// Comments are kept with their '#' or ';' indicators. Section names
+	// and keys have head comments, sections and values line comments, and
+	// the document a foot comment for the comments left at its end.
+	headComment string
+	lineComment string
+	footComment string
+
+	// parents names the sections inherited by a section, in order of
+	// precedence. It is nil for the default section and holds just the
+	// default section for the sections inheriting nothing else.
+	parents []string
+
+	// repeated marks the sequences made of a plain key repeated in its
+	// section, rather than of a key[] array key. They read as their last
+	// value unless decoded into a slice.
+	repeated bool
+
+	// inherited marks the nodes copied from an inherited section.
+	inherited bool
 }
 
 // ----------------------------------------------------------------------------
 // Parser, produces a node tree out of a ini document.
 
 type parser struct {
-	parser ini_parser_t
-	event  ini_event_t
-	doc    *node
+	parser   ini_parser_t
+	event    ini_event_t
+	doc      *node
+	doneInit bool
+
+	// raw leaves the keys of inherited sections out of the sections
+	// inheriting them, as the document model resolves them on lookup.
+	raw bool
+
+	// duplicates tells what to do with the keys and sections defined
+	// more than once.
+	duplicates DuplicatePolicy
+
+	// fold makes keys and section names match regardless of case.
+	fold bool
 }
 
 func newParser(b []byte) *parser {
@@ -47,12 +82,31 @@ func newParser(b []byte) *parser {
 	}
 
 	ini_parser_set_input_string(&p.parser, b)
+	p.init()
+	return &p
+}
+
+// newParserFromReader returns a parser reading from r. Nothing is read
+// until the parser is first used, so that input errors are reported by
+// the caller rather than when the parser is created.
+func newParserFromReader(r io.Reader) *parser {
+	p := parser{}
+	if !ini_parser_initialize(&p.parser) {
+		panic("failed to initialize INI parser")
+	}
+	ini_parser_set_input_reader(&p.parser, r)
+	return &p
+}
 
+func (p *parser) init() {
+	if p.doneInit {
+		return
+	}
 	p.skip()
 	if p.event.typ != ini_DOCUMENT_START_EVENT {
 		panic("expected ini_DOCUMENT_START_EVENT, got " + p.event.event_type())
 	}
-	return &p
+	p.doneInit = true
 }
 
 func (p *parser) destroy() {
@@ -75,23 +129,43 @@ func (p *parser) skip() {
 }
 
 func (p *parser) fail() {
-	var where string
-	var line int
-	if p.parser.problem_mark.line != 0 {
-		line = p.parser.problem_mark.line
-	} else if p.parser.context_mark.line != 0 {
-		line = p.parser.context_mark.line
-	}
-	if line != 0 {
-		where = "line " + strconv.Itoa(line) + ": "
-	}
 	var msg string
 	if len(p.parser.problem) > 0 {
 		msg = p.parser.problem
 	} else {
 		msg = "unknown problem parsing INI content"
 	}
-	failf("%s%s", where, msg)
+	if p.parser.error == ini_READER_ERROR {
+		// The input could not be read, which says nothing of its syntax.
+		failf("%s", msg)
+	}
+	mark := p.parser.problem_mark
+	fail(&SyntaxError{
+		Line:    mark.line + 1,
+		Column:  mark.column + 1,
+		Offset:  mark.index,
+		Context: p.parser.context,
+		Message: msg,
+	})
+}
+
+// syntaxErrors returns a ParseError holding the syntax errors a tolerant
+// parser recovered from, or nil if there are none.
+func (p *parser) syntaxErrors() error {
+	if len(p.parser.problems) == 0 {
+		return nil
+	}
+	e := &ParseError{}
+	for _, problem := range p.parser.problems {
+		e.Errors = append(e.Errors, &SyntaxError{
+			Line:    problem.problem_mark.line + 1,
+			Column:  problem.problem_mark.column + 1,
+			Offset:  problem.problem_mark.index,
+			Context: problem.context,
+			Message: problem.problem,
+		})
+	}
+	return e
 }
 
 func (p *parser) parse() *node {
@@ -126,16 +200,28 @@ func (p *parser) node(kind int) *node {
 
 func (p *parser) clone_node(n *node) *node {
 	thisNode := p.node(n.kind)
+	// Keep the position of the original, which may come from another
+	// source or from an inherited section.
+	thisNode.line, thisNode.column = n.line, n.column
+	thisNode.headComment, thisNode.lineComment = n.headComment, n.lineComment
 	thisNode.tag = n.tag
 	thisNode.value = n.value
+	thisNode.repeated = n.repeated
+	thisNode.inherited = n.inherited
 	for _, childNode := range n.children {
 		thisNode.children = append(thisNode.children, p.clone_node(childNode))
 	}
 	return thisNode
 }
 
+// The ways merge_node handles a key found in both nodes.
+const (
+	mergeKeep      = iota // Keep the value of the target.
+	mergeOverwrite        // Replace it with the value of the source.
+	mergeRepeat           // Follow the duplicate policy of the parser.
+)
 
-/**
+/*
 targetNode is: "hello": [1: "world"]
 sourceNode is: "hello": [1: [2: "world"]]
 overwrite result is:
@@ -145,9 +231,10 @@ no overwrite result is:
 
 Specific scene:
 when inherit a section , the expect operation for the same node is "no overwrite"
-in the same section, the expect operation for the same node is "overwrite"
+in the same section, the expect operation for the same node is "repeat", so
+that a repeated key keeps each of its values
 */
-func (p *parser) merge_node(targetNode *node, sourceNode *node, overwrite bool) {
+func (p *parser) merge_node(targetNode *node, sourceNode *node, mode int) {
 	if targetNode.kind == sourceNode.kind {
 		targetNodeCount := len(targetNode.children)
 		sourceNodeCount := len(sourceNode.children)
@@ -155,16 +242,22 @@ func (p *parser) merge_node(targetNode *node, sourceNode *node, overwrite bool)
 		for i := 0; i < sourceNodeCount; i += 2 {
 			nodeExist := false
 			for j := 0; j < targetNodeCount; j += 2 {
-				if sourceNode.children[i].kind == scalarNode && targetNode.children[j].kind == scalarNode && sourceNode.children[i].value == targetNode.children[j].value {
+				if sourceNode.children[i].kind == scalarNode && targetNode.children[j].kind == scalarNode && sameName(sourceNode.children[i].value, targetNode.children[j].value, p.fold) {
 					nodeExist = true
-					if sourceNode.children[i+1].kind == targetNode.children[j+1].kind {
-						if len(sourceNode.children[i+1].children) > 0 && len(targetNode.children[j+1].children) > 0 {
-							p.merge_node(targetNode.children[j+1], p.clone_node(sourceNode.children[i+1]), overwrite)
+					if mode == mergeRepeat && (isValueNode(sourceNode.children[i+1]) || isValueNode(targetNode.children[j+1])) {
+						targetNode.children[j+1] = p.repeat(targetNode.children[j], targetNode.children[j+1], p.clone_node(sourceNode.children[i+1]))
+					} else if sourceNode.children[i+1].kind == targetNode.children[j+1].kind {
+						if isValueNode(sourceNode.children[i+1]) {
+							if mode != mergeKeep {
+								targetNode.children[j+1] = p.clone_node(sourceNode.children[i+1])
+							}
+						} else if len(sourceNode.children[i+1].children) > 0 && len(targetNode.children[j+1].children) > 0 {
+							p.merge_node(targetNode.children[j+1], p.clone_node(sourceNode.children[i+1]), mode)
 						}
 					} else {
-                        if overwrite {
-                            targetNode.children[j + 1] = p.clone_node(sourceNode.children[i + 1])
-                        }
+						if mode != mergeKeep {
+							targetNode.children[j+1] = p.clone_node(sourceNode.children[i+1])
+						}
 					}
 					break
 				}
@@ -181,6 +274,76 @@ func (p *parser) merge_node(targetNode *node, sourceNode *node, overwrite bool)
 	return
 }
 
+// isValueNode returns whether n is the value of a key rather than a
+// mapping of dotted keys.
+func isValueNode(n *node) bool {
+	return n.kind == scalarNode || n.kind == sequenceNode
+}
+
+// repeat returns the value of the key defined again with the value n
+// after the value old, following the duplicate policy of the parser. An
+// array key adds its value to the values of the key whatever the policy.
+func (p *parser) repeat(key, old, n *node) *node {
+	if n.kind == sequenceNode && !n.repeated && isValueNode(old) {
+		return repeatNode(old, n)
+	}
+	switch p.duplicates {
+	case DuplicateError:
+		fail(&DefinitionError{
+			Name:    key.value,
+			Line:    n.line + 1,
+			Other:   old.line + 1,
+			Message: fmt.Sprintf("key '%s' is already defined at line %d", key.value, old.line+1),
+		})
+	case DuplicateFirstWins:
+		return old
+	}
+	if !isValueNode(old) || !isValueNode(n) {
+		return n
+	}
+	seq := repeatNode(old, n)
+	if p.duplicates == DuplicateAccumulate {
+		seq.repeated = false
+	}
+	return seq
+}
+
+// repeatNode returns the sequence of the values of a key defined again
+// with the value n after the value old. Both are kept, and the sequence
+// only reads as its last value if neither is an array.
+func repeatNode(old, n *node) *node {
+	seq := &node{kind: sequenceNode, line: old.line, column: old.column, repeated: true}
+	for _, value := range []*node{old, n} {
+		if value.kind == sequenceNode {
+			seq.repeated = seq.repeated && value.repeated
+			seq.children = append(seq.children, value.children...)
+		} else {
+			seq.children = append(seq.children, value)
+		}
+	}
+	return seq
+}
+
+// arrayEntry turns the entry of a plain key[] array key into the key and
+// a sequence holding its value.
+func arrayEntry(key, value *node) (*node, *node) {
+	if key.tag != "" || len(key.value) <= 2 || !strings.HasSuffix(key.value, "[]") || value.kind != scalarNode {
+		return key, value
+	}
+	key.value = key.value[:len(key.value)-2]
+	seq := &node{kind: sequenceNode, line: value.line, column: value.column, children: []*node{value}}
+	return key, seq
+}
+
+// lastValue returns the value a key reads as: the last value of a repeated
+// key, or n itself.
+func lastValue(n *node) *node {
+	if n.kind == sequenceNode && len(n.children) > 0 {
+		return n.children[len(n.children)-1]
+	}
+	return n
+}
+
 func (p *parser) document() *node {
 	n := p.node(documentNode)
 	p.doc = n
@@ -189,30 +352,152 @@ func (p *parser) document() *node {
 		keyNode := p.parse()
 		nextNode := p.parse()
 		if nextNode.kind == inheritNode {
-			childNode := p.parse()
-			// inherit
-			sectionExists := false
-			for i := 0; i < len(p.doc.children); i += 2 {
-				if p.doc.children[i].kind == scalarNode && p.doc.children[i].value == nextNode.value {
-					sectionExists = true
-					p.merge_node(childNode, p.clone_node(p.doc.children[i+1]), false)
-					break
-				}
-			}
-			if !sectionExists && nextNode.value != DEFAULT_SECTION {
-				failf("inherit section '%s' does not exists", nextNode.value)
+			var parents []string
+			for nextNode.kind == inheritNode {
+				parents = append(parents, nextNode.value)
+				nextNode = p.parse()
 			}
-			n.children = append(n.children, keyNode, childNode)
+			childNode := nextNode
+			childNode.parents = parents
+			p.addSection(n, keyNode, childNode)
 		} else if nextNode.kind == sectionNode {
-			n.children = append(n.children, keyNode, nextNode)
+			p.addSection(n, keyNode, nextNode)
 		}
 		p.skip()
 	}
+	n.footComment = string(p.event.foot_comment)
+	if !p.raw {
+		// Inherit once the whole document is known, so that a section
+		// may inherit the sections following it. The parents are merged
+		// first, and the sections listed first take precedence.
+		for _, body := range sectionOrder(n, p.fold) {
+			for _, parent := range body.parents {
+				if i := indexSection(n, parent, p.fold); i >= 0 && n.children[i+1] != body {
+					parentBody := p.clone_node(n.children[i+1])
+					markInherited(parentBody)
+					p.merge_node(body, parentBody, mergeKeep)
+				}
+			}
+		}
+	}
 	return n
 }
 
+// addSection adds the section called name to doc, following the duplicate
+// policy of the parser if doc already has a section of that name.
+func (p *parser) addSection(doc, name, body *node) {
+	if i := indexSection(doc, name.value, p.fold); i >= 0 {
+		switch p.duplicates {
+		case DuplicateError:
+			fail(&DefinitionError{
+				Name:    name.value,
+				Line:    name.line + 1,
+				Other:   doc.children[i].line + 1,
+				Message: fmt.Sprintf("section '%s' is already defined at line %d", name.value, doc.children[i].line+1),
+			})
+		case DuplicateFirstWins:
+			return
+		case DuplicateAccumulate:
+			first := doc.children[i+1]
+			parents := explicitParents(first)
+			for _, parent := range explicitParents(body) {
+				if !containsString(parents, parent) {
+					parents = append(parents, parent)
+				}
+			}
+			if parents != nil {
+				first.parents = parents
+			}
+			p.merge_node(first, body, mergeRepeat)
+			return
+		}
+	}
+	doc.children = append(doc.children, name, body)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// markInherited marks n and the nodes below it as inherited.
+func markInherited(n *node) {
+	n.inherited = true
+	for _, child := range n.children {
+		markInherited(child)
+	}
+}
+
+// sectionOrder returns the section bodies of doc, each one following the
+// sections it inherits. It fails if a section inherits a section that does
+// not exist or, through other sections, itself.
+func sectionOrder(doc *node, fold bool) []*node {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	var order []*node
+	state := make(map[*node]int)
+	var visit func(name, body *node)
+	visit = func(name, body *node) {
+		state[body] = visiting
+		for _, parent := range body.parents {
+			if sameName(parent, DEFAULT_SECTION, fold) && sameName(name.value, DEFAULT_SECTION, fold) {
+				continue
+			}
+			i := indexSection(doc, parent, fold)
+			if i < 0 {
+				if sameName(parent, DEFAULT_SECTION, fold) {
+					continue
+				}
+				fail(&DefinitionError{
+					Name:    name.value,
+					Line:    name.line + 1,
+					Message: fmt.Sprintf("section %q inherits undefined section %q", name.value, parent),
+				})
+			}
+			parentName, parentBody := doc.children[i], doc.children[i+1]
+			switch state[parentBody] {
+			case visiting:
+				fail(&DefinitionError{
+					Name:    parentName.value,
+					Line:    parentName.line + 1,
+					Other:   name.line + 1,
+					Message: fmt.Sprintf("section '%s' and line %d: section '%s' inherit each other", parentName.value, name.line+1, name.value),
+				})
+			case 0:
+				visit(parentName, parentBody)
+			}
+		}
+		state[body] = visited
+		order = append(order, body)
+	}
+	for i := 0; i < len(doc.children); i += 2 {
+		if state[doc.children[i+1]] == 0 {
+			visit(doc.children[i], doc.children[i+1])
+		}
+	}
+	return order
+}
+
+// indexSection returns the index of the name of the first section of doc
+// named name, or -1 if there is none.
+func indexSection(doc *node, name string, fold bool) int {
+	for i := 0; i < len(doc.children); i += 2 {
+		if sameName(doc.children[i].value, name, fold) {
+			return i
+		}
+	}
+	return -1
+}
+
 func (p *parser) section() *node {
 	thisNode := p.node(sectionNode)
+	thisNode.lineComment = string(p.event.line_comment)
 
 	// until next ini_SECTION_START_EVENT
 	p.skip()
@@ -224,10 +509,17 @@ func (p *parser) section() *node {
 		}
 		if currentNodeKey.kind == scalarNode {
 			currentNodeValue := p.parse()
+			currentNodeKey, currentNodeValue = arrayEntry(currentNodeKey, currentNodeValue)
+			if i := indexNode(parentNode, currentNodeKey.value, p.fold); i >= 0 && parentNode.children[i+1].kind != currentNodeValue.kind && !(isValueNode(parentNode.children[i+1]) && isValueNode(currentNodeValue)) {
+				// the key is redefined with another kind of value
+				if p.repeat(parentNode.children[i], parentNode.children[i+1], currentNodeValue) != currentNodeValue {
+					continue
+				}
+			}
 			swapChildNodes := make([]*node, 0)
 			for i := 0; i < len(parentNode.children); i += 2 {
-				if parentNode.children[i].value == currentNodeKey.value {
-					if parentNode.children[i+1].kind == currentNodeValue.kind {
+				if sameName(parentNode.children[i].value, currentNodeKey.value, p.fold) {
+					if parentNode.children[i+1].kind == currentNodeValue.kind || isValueNode(parentNode.children[i+1]) && isValueNode(currentNodeValue) {
 						swapChildNodes = append(swapChildNodes, parentNode.children[i], parentNode.children[i+1])
 					}
 				} else {
@@ -241,13 +533,16 @@ func (p *parser) section() *node {
 				// condition:
 				// 1. current node type
 				// 2. current node value
-				if currentNodeKey.kind == scalarNode && parentNode.children[i].kind == scalarNode && currentNodeKey.value == parentNode.children[i].value {
+				if currentNodeKey.kind == scalarNode && parentNode.children[i].kind == scalarNode && sameName(currentNodeKey.value, parentNode.children[i].value, p.fold) {
 					nodeExist = true
-					// if current node value type is different, overwrite it
-					if parentNode.children[i+1].kind != currentNodeValue.kind {
+					// a repeated key follows the duplicate policy
+					if isValueNode(parentNode.children[i+1]) && isValueNode(currentNodeValue) {
+						parentNode.children[i+1] = p.repeat(parentNode.children[i], parentNode.children[i+1], p.clone_node(currentNodeValue))
+					} else if parentNode.children[i+1].kind != currentNodeValue.kind {
+						// if current node value type is different, overwrite it
 						parentNode.children[i+1] = p.clone_node(currentNodeValue)
 					} else {
-						p.merge_node(parentNode.children[i+1], p.clone_node(currentNodeValue), true)
+						p.merge_node(parentNode.children[i+1], p.clone_node(currentNodeValue), mergeRepeat)
 					}
 					break
 				}
@@ -272,13 +567,14 @@ func (p *parser) mapping() *node {
 	}
 	if currentNodeKey.kind == scalarNode {
 		currentNodeValue := p.parse()
+		currentNodeKey, currentNodeValue = arrayEntry(currentNodeKey, currentNodeValue)
 		nodeExist := false
 		i := 0
 		for ; i < len(parentNode.children); i += 2 {
 			// condition:
 			// 1. current node type
 			// 2. current node value
-			if currentNodeKey.kind == parentNode.children[i].kind && currentNodeKey.value == parentNode.children[i].value {
+			if currentNodeKey.kind == parentNode.children[i].kind && sameName(currentNodeKey.value, parentNode.children[i].value, p.fold) {
 				nodeExist = true
 				break
 			}
@@ -289,7 +585,7 @@ func (p *parser) mapping() *node {
 				if parentNode.children[i+1].kind != currentNodeValue.kind {
 					parentNode.children[i+1] = p.clone_node(currentNodeValue)
 				} else {
-					p.merge_node(parentNode.children[i+1], p.clone_node(currentNodeValue), true)
+					p.merge_node(parentNode.children[i+1], p.clone_node(currentNodeValue), mergeRepeat)
 				}
 				parentNode = parentNode.children[i+1]
 			}
@@ -321,6 +617,12 @@ func (p *parser) scalar() *node {
 	thisNode := p.node(scalarNode)
 	thisNode.value = string(p.event.value)
 	thisNode.tag = string(p.event.tag)
+	thisNode.headComment = string(p.event.head_comment)
+	thisNode.lineComment = string(p.event.line_comment)
+	if thisNode.tag == "" && p.event.scalar_style() != ini_PLAIN_SCALAR_STYLE {
+		// Quoted scalars are always strings.
+		thisNode.tag = ini_STR_TAG
+	}
 	p.skip()
 	return thisNode
 }
@@ -330,8 +632,39 @@ func (p *parser) scalar() *node {
 
 type decoder struct {
 	doc     *node
+	nested  *node
 	mapType reflect.Type
-	terrors []string
+	terrors []*DecodeError
+
+	// interpolation replaces the ${key} references of values with the
+	// values they refer to.
+	interpolation bool
+
+	// lookupEnv looks up the environment variables referred to by
+	// values, or is nil to leave such references alone.
+	lookupEnv func(string) (string, bool)
+
+	// delimiter separates the items of a value decoded into a slice, or
+	// is empty for values holding a single item.
+	delimiter string
+
+	// knownFields makes the keys and sections matching no struct field
+	// an error.
+	knownFields bool
+
+	// fold makes keys and section names match struct fields and each
+	// other regardless of case.
+	fold bool
+
+	// section and path locate the value being decoded: the name of its
+	// section and the keys leading to it.
+	section string
+	path    []string
+
+	// checks holds the decoded fields to check against their constraints,
+	// and violations the required fields no key was decoded into.
+	checks     []check
+	violations []Violation
 }
 
 var (
@@ -356,7 +689,15 @@ func (d *decoder) terror(n *node, tag string, out reflect.Value) {
 	} else {
 		value = " `" + value + "`"
 	}
-	d.terrors = append(d.terrors, fmt.Sprintf("line %d: cannot unmarshal %s%s into %s", n.line+1, tag, value, out.Type()))
+	d.terrors = append(d.terrors, &DecodeError{
+		Section: d.section,
+		KeyPath: d.path,
+		Line:    n.line + 1,
+		Column:  n.column + 1,
+		Value:   n.value,
+		Type:    out.Type(),
+		Message: fmt.Sprintf("cannot unmarshal %s%s into %s", tag, value, out.Type()),
+	})
 }
 
 func (d *decoder) callUnmarshaler(n *node, u Unmarshaler) (good bool) {
@@ -367,12 +708,12 @@ func (d *decoder) callUnmarshaler(n *node, u Unmarshaler) (good bool) {
 		if len(d.terrors) > terrlen {
 			issues := d.terrors[terrlen:]
 			d.terrors = d.terrors[:terrlen]
-			return &TypeError{issues}
+			return newTypeError(issues)
 		}
 		return nil
 	})
 	if e, ok := err.(*TypeError); ok {
-		d.terrors = append(d.terrors, e.Errors...)
+		d.terrors = append(d.terrors, e.details()...)
 		return false
 	}
 	if err != nil {
@@ -427,6 +768,8 @@ func (d *decoder) unmarshal(n *node, out reflect.Value) (good bool) {
 		good = d.mapping(n, out)
 	case scalarNode:
 		good = d.scalar(n, out)
+	case sequenceNode:
+		good = d.sequence(n, out)
 	default:
 		panic("internal error: unknown node kind: " + strconv.Itoa(n.kind))
 	}
@@ -435,47 +778,75 @@ func (d *decoder) unmarshal(n *node, out reflect.Value) (good bool) {
 
 func (d *decoder) document(n *node, out reflect.Value) (good bool) {
 	if len(n.children) > 0 {
-		d.doc = n
+		if d.doc != n {
+			d.doc = n
+			if d.interpolation || d.lookupEnv != nil {
+				d.interpolate(n)
+			}
+			d.nested = nestSections(n, d.fold)
+		}
+		n = d.nested
 		switch out.Kind() {
 		case reflect.Struct:
 			sinfo, err := getStructInfo(out.Type())
 			if err != nil {
 				panic(err)
 			}
+			decoded := make(map[string]bool)
 			k := settableValueOf("")
 			l := len(n.children)
 			for i := 0; i < l; i += 2 {
 				if !d.unmarshal(n.children[i], k) {
 					continue
 				}
-				if n.children[i].value == DEFAULT_SECTION {
+				if sameName(n.children[i].value, DEFAULT_SECTION, d.fold) {
+					d.section = DEFAULT_SECTION
 					ll := len(n.children[i+1].children)
 					for j := 0; j < ll; j += 2 {
 						if !d.unmarshal(n.children[i+1].children[j], k) {
 							continue
 						}
-						if info, ok := sinfo.FieldsMap[k.String()]; ok {
+						if info, ok := d.field(sinfo, k.String()); ok {
 							var field reflect.Value
 							if info.Inline == nil {
 								field = out.Field(info.Num)
 							} else {
 								field = out.FieldByIndex(info.Inline)
 							}
+							restore := d.descend(k.String(), n.children[i+1].children[j+1])
 							d.unmarshal(n.children[i+1].children[j+1], field)
+							restore()
+							d.constrain(info, field, DEFAULT_SECTION, k.String(), n.children[i+1].children[j].line+1)
+							decoded[info.Key] = true
+						} else if d.knownFields {
+							d.unknownKey(n.children[i+1].children[j])
 						}
 					}
 				} else {
-					if info, ok := sinfo.FieldsMap[k.String()]; ok {
+					if info, ok := d.field(sinfo, k.String()); ok {
 						var field reflect.Value
 						if info.Inline == nil {
 							field = out.Field(info.Num)
 						} else {
 							field = out.FieldByIndex(info.Inline)
 						}
+						d.section = n.children[i].value
 						d.unmarshal(n.children[i+1], field)
+						d.constrain(info, field, d.section, "", n.children[i].line+1)
+						decoded[info.Key] = true
+					} else if d.knownFields {
+						d.terrors = append(d.terrors, &DecodeError{
+							Section: n.children[i].value,
+							Line:    n.children[i].line + 1,
+							Column:  n.children[i].column + 1,
+							Value:   n.children[i].value,
+							Message: fmt.Sprintf("unknown section '%s'", n.children[i].value),
+						})
 					}
 				}
+				d.section = ""
 			}
+			d.missing(n, sinfo, out, decoded)
 			return true
 		case reflect.Slice:
 			outt := out.Type()
@@ -495,7 +866,7 @@ func (d *decoder) document(n *node, out reflect.Value) (good bool) {
 				if !d.unmarshal(n.children[i], k) {
 					continue
 				}
-				if n.children[i].value == DEFAULT_SECTION {
+				if sameName(n.children[i].value, DEFAULT_SECTION, d.fold) {
 					ll := len(n.children[i+1].children)
 					for j := 0; j < ll; j += 2 {
 						item := MapItem{}
@@ -563,7 +934,7 @@ func (d *decoder) document(n *node, out reflect.Value) (good bool) {
 				failf("invalid map key: %#v", k.Interface())
 			}
 			e := reflect.New(et).Elem()
-			if n.children[i].value == DEFAULT_SECTION {
+			if sameName(n.children[i].value, DEFAULT_SECTION, d.fold) {
 				d.unmarshal(n.children[i+1], out)
 			} else {
 				if d.unmarshal(n.children[i+1], e) {
@@ -574,9 +945,83 @@ func (d *decoder) document(n *node, out reflect.Value) (good bool) {
 		d.mapType = mapType
 		return true
 	}
+	if out.Kind() == reflect.Struct {
+		sinfo, err := getStructInfo(out.Type())
+		if err != nil {
+			panic(err)
+		}
+		d.missing(n, sinfo, out, nil)
+	}
 	return false
 }
 
+// sectionPath splits a section name into the path of the section it
+// names. The dots of the name separate the segments of the path, and a
+// quoted subsection, as in [remote "origin"], is a segment of its own.
+func sectionPath(name string) []string {
+	if i := strings.Index(name, ` "`); i >= 0 {
+		if sub, err := strconv.Unquote(name[i+1:]); err == nil {
+			return append(strings.Split(name[:i], "."), sub)
+		}
+	}
+	return strings.Split(name, ".")
+}
+
+// nestSections returns doc with every section named after a dotted path,
+// such as [database.primary], moved into the section named after the
+// first segment of the path, where it is the value of the nested key
+// "primary" of the mapping "database". The section named after the first
+// segment is made up if the document has none. A nested section replaces
+// the key it is named after.
+func nestSections(doc *node, fold bool) *node {
+	nested := &node{kind: documentNode, line: doc.line, column: doc.column}
+	implicit := make(map[*node]bool)
+	for i := 0; i < len(doc.children); i += 2 {
+		name, body := doc.children[i], doc.children[i+1]
+		path := sectionPath(name.value)
+		j := indexNode(nested, path[0], fold)
+		if len(path) == 1 {
+			if j >= 0 && implicit[nested.children[j+1]] {
+				// The section comes after sections nested in it.
+				for k := 0; k < len(nested.children[j+1].children); k += 2 {
+					setNode(body, nested.children[j+1].children[k], nested.children[j+1].children[k+1], fold)
+				}
+				nested.children[j+1] = body
+			} else {
+				nested.children = append(nested.children, name, body)
+			}
+			continue
+		}
+		if j < 0 {
+			top := &node{kind: sectionNode, line: name.line, column: name.column}
+			implicit[top] = true
+			nested.children = append(nested.children, &node{kind: scalarNode, tag: ini_STR_TAG, value: path[0], line: name.line, column: name.column}, top)
+			j = len(nested.children) - 2
+		}
+		n := nested.children[j+1]
+		for _, segment := range path[1 : len(path)-1] {
+			k := indexNode(n, segment, fold)
+			if k < 0 || n.children[k+1].kind == scalarNode {
+				setNode(n, &node{kind: scalarNode, tag: ini_STR_TAG, value: segment, line: name.line, column: name.column}, &node{kind: mappingNode, line: name.line, column: name.column}, fold)
+				k = indexNode(n, segment, fold)
+			}
+			n = n.children[k+1]
+		}
+		setNode(n, &node{kind: scalarNode, tag: ini_STR_TAG, value: path[len(path)-1], line: name.line, column: name.column}, body, fold)
+	}
+	return nested
+}
+
+// setNode sets the value of key in the section or mapping node n,
+// replacing the value of the key with the same name if any.
+func setNode(n, key, value *node, fold bool) {
+	if i := indexNode(n, key.value, fold); i >= 0 {
+		n.children[i+1] = value
+		return
+	}
+	n.children = append(n.children, key, value)
+}
+
 var zeroValue reflect.Value
 
 func resetMap(out reflect.Value) {
@@ -632,7 +1077,16 @@ func (d *decoder) mapping(n *node, out reflect.Value) (good bool) {
 	l := len(n.children)
 	for i := 0; i < l; i += 2 {
 		k := reflect.New(kt).Elem()
-		if d.unmarshal(n.children[i], k) {
+		key := n.children[i]
+		if kt.Kind() == reflect.String && key.kind == scalarNode {
+			// A null key still names its entry.
+			if tag, _ := resolve(key.tag, key.value); tag == ini_NULL_TAG {
+				str := *key
+				str.tag = ini_STR_TAG
+				key = &str
+			}
+		}
+		if d.unmarshal(key, k) {
 			kkind := k.Kind()
 			if kkind == reflect.Interface {
 				kkind = k.Elem().Kind()
@@ -682,25 +1136,218 @@ func (d *decoder) mappingStruct(n *node, out reflect.Value) (good bool) {
 	if err != nil {
 		panic(err)
 	}
+	decoded := make(map[string]bool)
 	name := settableValueOf("")
 	l := len(n.children)
 	for i := 0; i < l; i += 2 {
 		if !d.unmarshal(n.children[i], name) {
 			continue
 		}
-		if info, ok := sinfo.FieldsMap[name.String()]; ok {
+		if info, ok := d.field(sinfo, name.String()); ok {
 			var field reflect.Value
 			if info.Inline == nil {
 				field = out.Field(info.Num)
 			} else {
 				field = out.FieldByIndex(info.Inline)
 			}
+			restore := d.descend(name.String(), n.children[i+1])
 			d.unmarshal(n.children[i+1], field)
+			restore()
+			d.constrain(info, field, d.section, d.key(info.Key), n.children[i].line+1)
+			decoded[info.Key] = true
+		} else if d.knownFields {
+			d.unknownKey(n.children[i])
 		}
 	}
+	d.missing(n, sinfo, out, decoded)
 	return true
 }
 
+// missing handles the fields of the struct out that no key was decoded
+// into. It unmarshals their default tags, the same way as plain values of
+// such keys found at n, and reports the required ones at the line of the
+// section holding them, or at no line if the document does not define
+// it. The fields of a nested struct no section or key was decoded into
+// are handled as well.
+func (d *decoder) missing(n *node, sinfo *structInfo, out reflect.Value, decoded map[string]bool) {
+	line := n.line + 1
+	if n.kind == documentNode {
+		line = 0
+		if i := indexSection(n, DEFAULT_SECTION, d.fold); i >= 0 {
+			line = n.children[i].line + 1
+		}
+	}
+	for _, info := range sinfo.FieldsList {
+		if decoded[info.Key] {
+			continue
+		}
+		var field reflect.Value
+		if info.Inline == nil {
+			field = out.Field(info.Num)
+		} else {
+			field = out.FieldByIndex(info.Inline)
+		}
+		section, key := d.section, d.key(info.Key)
+		fieldLine := line
+		top := n.kind == documentNode && section == ""
+		if top {
+			section = DEFAULT_SECTION
+			if k := indirect(field).Kind(); !info.Flow && (k == reflect.Struct || k == reflect.Map) {
+				section, key = info.Key, ""
+				fieldLine = 0
+			}
+		}
+		d.require(info, section, key, fieldLine)
+		if info.Default != nil {
+			d.unmarshal(&node{kind: scalarNode, line: fieldLine - 1, column: -1, value: *info.Default}, field)
+			d.constrain(info, field, section, key, fieldLine)
+			continue
+		}
+		if field.Kind() != reflect.Struct {
+			continue
+		}
+		if field.CanAddr() {
+			if _, ok := field.Addr().Interface().(Unmarshaler); ok {
+				continue
+			}
+		}
+		fsinfo, err := getStructInfo(field.Type())
+		if err != nil {
+			panic(err)
+		}
+		body := &node{kind: sectionNode, line: fieldLine - 1}
+		restore := d.descend(info.Key, body)
+		if top {
+			d.section, d.path = section, nil
+			if key != "" {
+				d.path = []string{key}
+			}
+		}
+		d.missing(body, fsinfo, field, nil)
+		restore()
+	}
+}
+
+// key returns the full dotted name of the key called name, below the
+// keys being decoded.
+func (d *decoder) key(name string) string {
+	return strings.Join(append(d.path[:len(d.path):len(d.path)], name), ".")
+}
+
+// field returns the field of the struct described by sinfo that the key
+// called name is decoded into.
+func (d *decoder) field(sinfo *structInfo, name string) (fieldInfo, bool) {
+	info, ok := sinfo.FieldsMap[name]
+	if !ok && d.fold {
+		for _, info := range sinfo.FieldsList {
+			if strings.EqualFold(info.Key, name) {
+				return info, true
+			}
+		}
+	}
+	return info, ok
+}
+
+// descend makes key the current key of the decoder, below the keys being
+// decoded, or the current section if its value n is a nested section. It
+// returns a function restoring the previous ones.
+func (d *decoder) descend(key string, n *node) (restore func()) {
+	section, path := d.section, d.path
+	if n.kind == sectionNode {
+		d.section, d.path = section+"."+key, nil
+	} else {
+		d.path = append(path[:len(path):len(path)], key)
+	}
+	return func() { d.section, d.path = section, path }
+}
+
+// unknownKey reports the key matching no struct field, unless it is
+// inherited and so reported in the section defining it.
+func (d *decoder) unknownKey(key *node) {
+	if key.inherited {
+		return
+	}
+	path := append(d.path[:len(d.path):len(d.path)], key.value)
+	d.terrors = append(d.terrors, &DecodeError{
+		Section: d.section,
+		KeyPath: path,
+		Line:    key.line + 1,
+		Column:  key.column + 1,
+		Value:   key.value,
+		Message: fmt.Sprintf("unknown key '%s' in section '%s'", strings.Join(path, "."), d.section),
+	})
+}
+
+// sequence unmarshals the values of a repeated or key[] array key into a
+// slice, or into a []interface{} for an interface. A repeated key reads
+// as its last value into anything else, the same way as a plain key.
+func (d *decoder) sequence(n *node, out reflect.Value) (good bool) {
+	switch out.Kind() {
+	case reflect.Slice:
+		if out.Type().Elem() != mapItemType {
+			return d.items(n.children, out)
+		}
+	case reflect.Interface:
+		if !n.repeated {
+			var items []interface{}
+			d.items(n.children, reflect.ValueOf(&items).Elem())
+			out.Set(reflect.ValueOf(items))
+			return true
+		}
+	}
+	if n.repeated {
+		return d.unmarshal(lastValue(n), out)
+	}
+	d.terror(n, ini_SEQ_TAG, out)
+	return false
+}
+
+// items unmarshals each of the nodes into an element of the slice out.
+func (d *decoder) items(nodes []*node, out reflect.Value) (good bool) {
+	et := out.Type().Elem()
+	slice := reflect.MakeSlice(out.Type(), 0, len(nodes))
+	for _, n := range nodes {
+		e := reflect.New(et).Elem()
+		if d.unmarshal(n, e) {
+			slice = reflect.Append(slice, e)
+		}
+	}
+	out.Set(slice)
+	return true
+}
+
+// split returns the items of the scalar n decoded into a slice: the parts
+// of its value separated by the delimiter, or n itself. A '\' escapes a
+// delimiter or another '\' inside an item, and an empty value has no
+// items.
+func (d *decoder) split(n *node) []*node {
+	if d.delimiter == "" {
+		return []*node{n}
+	}
+	if n.value == "" {
+		return nil
+	}
+	var items []*node
+	var item []byte
+	for i := 0; i <= len(n.value); i++ {
+		switch {
+		case i == len(n.value) || strings.HasPrefix(n.value[i:], d.delimiter):
+			items = append(items, &node{kind: scalarNode, line: n.line, column: n.column, tag: n.tag, value: strings.TrimSpace(string(item))})
+			item = nil
+			i += len(d.delimiter) - 1
+		case n.value[i] == '\\' && i+1 < len(n.value) && n.value[i+1] == '\\':
+			item = append(item, '\\')
+			i++
+		case n.value[i] == '\\' && strings.HasPrefix(n.value[i+1:], d.delimiter):
+			item = append(item, d.delimiter...)
+			i += len(d.delimiter)
+		default:
+			item = append(item, n.value[i])
+		}
+	}
+	return items
+}
+
 func (d *decoder) scalar(n *node, out reflect.Value) (good bool) {
 	var tag string
 	var resolved interface{}
@@ -840,6 +1487,10 @@ func (d *decoder) scalar(n *node, out reflect.Value) (good bool) {
 			out.SetFloat(resolved)
 			good = true
 		}
+	case reflect.Slice:
+		if out.Type().Elem() != mapItemType {
+			good = d.items(d.split(n), out)
+		}
 	case reflect.Ptr:
 		if out.Type().Elem() == reflect.TypeOf(resolved) {
 			// TODO DOes this make sense? When is out a Ptr except when decoding a nil value?
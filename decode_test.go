@@ -2,9 +2,14 @@ package ini_test
 
 import (
 	"errors"
+	"fmt"
 	. "gopkg.in/check.v1"
+	"io"
 	"math"
 	"reflect"
+	"strings"
+	"testing/iotest"
+	"time"
 
 	"go-ini"
 )
@@ -271,6 +276,18 @@ var unmarshalTests = []struct {
 	}, {
 		"v = 'B' ",
 		map[string]interface{}{"v": "B"},
+	}, {
+		"v = \"true\"",
+		map[string]interface{}{"v": "true"},
+	}, {
+		"v = 'it''s'",
+		map[string]interface{}{"v": "it's"},
+	}, {
+		"v = \"say \\\"hi\\\"\\tnow=\\u00e9\"",
+		map[string]interface{}{"v": "say \"hi\"\tnow=\u00e9"},
+	}, {
+		"\"n\" = ''",
+		map[string]interface{}{"n": ""},
 	}, {
 		"hello.1= world_1",
 		map[string]map[int]interface{}{
@@ -411,6 +428,16 @@ var unmarshalTests = []struct {
 				},
 			},
 		},
+	}, {
+		"[section]\nhello.1= world\nhello.1= world_1\nhello.2= world_2",
+		map[string]interface{}{
+			"section": map[interface{}]interface{}{
+				"hello": map[interface{}]interface{}{
+					1: "world_1",
+					2: "world_2",
+				},
+			},
+		},
 	},
 	// struct conversions
 	{
@@ -476,8 +503,218 @@ var unmarshalTests = []struct {
 			}{struct{ A struct{ B string } }{struct{ B string }{"world"}}},
 		},
 	},
+
+	// struct tag options
+	{
+		"a= 1\nb= 2\nc= 3",
+		&struct {
+			A     int
+			Inner struct {
+				B int `ini:"b,omitempty"`
+				C int
+			} `ini:",inline"`
+		}{1, struct {
+			B int `ini:"b,omitempty"`
+			C int
+		}{2, 3}},
+	}, {
+		"[section]\na= 1\nb= 2",
+		&struct {
+			Section struct {
+				Inner struct{ A, B int } `ini:",inline"`
+			}
+		}{struct {
+			Inner struct{ A, B int } `ini:",inline"`
+		}{struct{ A, B int }{1, 2}}},
+	}, {
+		"server.port= 8080",
+		&struct {
+			Server struct{ Port int } `ini:",flow"`
+		}{struct{ Port int }{8080}},
+	}, {
+
+		// Comments
+		"; comment\n[section] # comment\n\n# comment\nv = 10 ; comment\nw = \"a ; b\" ; comment\n; comment",
+		map[string]map[string]interface{}{"section": {"v": 10, "w": "a ; b"}},
+	}, {
+		"color = #fff\nurl = a;b",
+		map[string]string{"color": "#fff", "url": "a;b"},
+	},
+
+	// Multi-line values
+	{
+		"v = \"a\n  b\\\n  c\"\nw = \"\\\n\"",
+		map[string]string{"v": "a\n  bc", "w": ""},
+	}, {
+		"[s]\n\ta = 1\n\tb = 2\n",
+		map[string]map[string]string{"s": {"a": "1", "b": "2"}},
+	}, {
+		"dir = C:\\temp\\\nother = 1",
+		map[string]string{"dir": "C:\\temp\\", "other": "1"},
+	},
+
+	// Arrays
+	{
+		"server = a\nserver = b\nport[] = 80\nport[] = 443\nname = x\nname = y",
+		&struct {
+			Server []string
+			Port   []int
+			Name   string
+		}{[]string{"a", "b"}, []int{80, 443}, "y"},
+	}, {
+		"a = 1\na = 2\nb[] = 1\nb[] = 2\nc[] = x\n[s]\nt.u[] = v\nt.u[] = w",
+		map[string]interface{}{
+			"a": 2,
+			"b": []interface{}{1, 2},
+			"c": []interface{}{"x"},
+			"s": map[interface{}]interface{}{
+				"a": 2, "b": []interface{}{1, 2}, "c": []interface{}{"x"},
+				"t": map[interface{}]interface{}{"u": []interface{}{"v", "w"}},
+			},
+		},
+	}, {
+		"v = a, b",
+		map[string][]string{"v": {"a, b"}},
+	}, {
+		"[a]\nv[] = x\n[b:a]\nv[] = y\n",
+		map[string]map[string][]string{"a": {"v": {"x"}}, "b": {"v": {"y"}}},
+	},
+
+	// Multiple inheritance
+	{
+		"region = none\n[prod]\nhost = prod\nlevel = warn\n[eu-common]\nhost = eu\nregion = eu\nlang = de\n[prod-eu : prod, eu-common]\nlang = fr",
+		map[string]map[string]string{
+			"prod":      {"region": "none", "host": "prod", "level": "warn"},
+			"eu-common": {"region": "eu", "host": "eu", "lang": "de"},
+			"prod-eu":   {"region": "none", "host": "prod", "level": "warn", "lang": "fr"},
+		},
+	}, {
+		"[a]\nx = a\n[b]\nx = b\ny = b\n[c:b,a]\n",
+		map[string]map[string]string{"a": {"x": "a"}, "b": {"x": "b", "y": "b"}, "c": {"x": "b", "y": "b"}},
+	},
+
+	// Forward and transitive inheritance
+	{
+		"hello= world\n[section_2:section_1]\nhello_2= world\n[section_1]\nhello_1= world",
+		map[string]interface{}{
+			"hello":     "world",
+			"section_2": map[interface{}]interface{}{"hello": "world", "hello_1": "world", "hello_2": "world"},
+			"section_1": map[interface{}]interface{}{"hello": "world", "hello_1": "world"},
+		},
+	}, {
+		"[c:b]\nz = c\n[b:a]\ny = b\nz = b\n[a]\nx = a\ny = a\nz = a",
+		map[string]map[string]string{
+			"a": {"x": "a", "y": "a", "z": "a"},
+			"b": {"x": "a", "y": "b", "z": "b"},
+			"c": {"x": "a", "y": "b", "z": "c"},
+		},
+	},
+
+	// Nested sections
+	{
+		"[database]\nname = main\n[database.primary]\nhost = a\n[database.replica.eu : database.primary]\nport = 5432",
+		&struct {
+			Database struct {
+				Name    string
+				Primary struct{ Host string }
+				Replica struct {
+					EU struct {
+						Host string
+						Port int
+					} `ini:"eu"`
+				}
+			}
+		}{struct {
+			Name    string
+			Primary struct{ Host string }
+			Replica struct {
+				EU struct {
+					Host string
+					Port int
+				} `ini:"eu"`
+			}
+		}{"main", struct{ Host string }{"a"}, struct {
+			EU struct {
+				Host string
+				Port int
+			} `ini:"eu"`
+		}{struct {
+			Host string
+			Port int
+		}{"a", 5432}}}},
+	}, {
+		"[a.b]\nx = 1\n[a]\nz = 2\nb = 3\n[c]\nd = 4\n[c.d]\ne = 5",
+		map[string]interface{}{
+			"a": map[interface{}]interface{}{"z": 2, "b": map[interface{}]interface{}{"x": 1}},
+			"c": map[interface{}]interface{}{"d": map[interface{}]interface{}{"e": 5}},
+		},
+	}, {
+		"[remote \"origin\"]\nurl = a\n[remote \"my \\\"fork\\\"\" : remote \"origin\"]\nfetch = b",
+		map[string]interface{}{
+			"remote": map[interface{}]interface{}{
+				"origin":      map[interface{}]interface{}{"url": "a"},
+				"my \"fork\"": map[interface{}]interface{}{"url": "a", "fetch": "b"},
+			},
+		},
+	},
+
+	// References are left alone without interpolation.
+	{
+		"host = h\nurl = ${host}\nraw = $${host}\nmissing = ${missing}",
+		map[string]string{"host": "h", "url": "${host}", "raw": "$${host}", "missing": "${missing}"},
+	},
+
+	// Defaults
+	{
+		"",
+		&struct {
+			Port  int           `default:"8080"`
+			Debug bool          `default:"true"`
+			Wait  time.Duration `default:"1m30s"`
+			Name  *string       `default:"main"`
+		}{8080, true, 90 * time.Second, &unmarshalDefaultName},
+	}, {
+		"workers = 2\n[db]\nhost = h\n",
+		&struct {
+			Workers int `default:"4"`
+			DB      struct {
+				Host string `default:"localhost"`
+				Port int    `default:"5432"`
+			}
+			Cache struct {
+				Size int `default:"64"`
+			}
+		}{2, struct {
+			Host string `default:"localhost"`
+			Port int    `default:"5432"`
+		}{"h", 5432}, struct {
+			Size int `default:"64"`
+		}{64}},
+	}, {
+		"[server]\nname = a\n[server.tls]\ncert = c",
+		&struct {
+			Server struct {
+				Name string
+				TLS  struct {
+					Cert string
+					Port int `default:"443"`
+				}
+			}
+		}{struct {
+			Name string
+			TLS  struct {
+				Cert string
+				Port int `default:"443"`
+			}
+		}{"a", struct {
+			Cert string
+			Port int `default:"443"`
+		}{"c", 443}}},
+	},
 }
 
+var unmarshalDefaultName = "main"
+
 type M map[interface{}]interface{}
 
 func (s *S) TestUnmarshal(c *C) {
@@ -527,13 +764,53 @@ var unmarshalErrorTests = []struct {
 		"hello: world",
 		"ini: line 1: did not find expected <value> or <map>",
 	},
+	{
+		"[ok]\nx = 1\nbare\n",
+		"ini: line 3: did not find expected <value> or <map>",
+	},
+	{
+		"[a]\n[b]\n[c : a, missing]",
+		`ini: line 3: section "c" inherits undefined section "missing"`,
+	},
+	{
+		"[a b]",
+		"ini: line 1: found a blank inside the section key",
+	},
+	{
+		"[a]\n[b:a,]",
+		"ini: line 2: found an empty inherited section name",
+	},
+	{
+		"[a..b]",
+		"ini: line 1: found an empty segment in the dotted section key",
+	},
+	{
+		"[a \"b\" c]",
+		"ini: line 1: did not find expected ']' after the subsection",
+	},
+	{
+		"[a \"b]",
+		"ini: line 1: found unexpected end of line",
+	},
+	{
+		"v = 'a\nb'",
+		"ini: line 1: found unexpected line break",
+	},
 	{
 		"[section]'hello'= \"world\"",
-		"ini: must have a line break before the first section key",
+		"ini: line 1: must have a line break before the first section key",
 	},
 	{
-		"hello= world\n[section_2:section_1]\nhello_2= world\n[section_1]\nhello_1= world",
-		"ini: inherit section 'section_1' does not exists",
+		"hello= world\n[section_2:section_1]\nhello_2= world\n[section_1:section_0]\nhello_1= world",
+		`ini: line 4: section "section_1" inherits undefined section "section_0"`,
+	},
+	{
+		"[a:c]\n[b:a]\n[c:b]\n",
+		"ini: line 1: section 'a' and line 2: section 'b' inherit each other",
+	},
+	{
+		"[a:a]\n",
+		"ini: line 1: section 'a' and line 1: section 'a' inherit each other",
 	},
 }
 
@@ -545,6 +822,646 @@ func (s *S) TestUnmarshalErrors(c *C) {
 	}
 }
 
+func (s *S) TestUnmarshalDefaultError(c *C) {
+	var value struct {
+		Port int `default:"http"`
+		Host string
+	}
+	err := ini.Unmarshal([]byte("\n\nhost = h"), &value)
+	c.Assert(err, ErrorMatches, "ini: unmarshal errors:\n  line 3: cannot unmarshal str `http` into int")
+	c.Assert(value.Host, Equals, "h")
+
+	// The line is the one of the section, or none for a missing section.
+	var sections struct {
+		S struct {
+			Port int `default:"http"`
+		}
+		T struct {
+			Port int `default:"http"`
+		}
+	}
+	err = ini.Unmarshal([]byte("a = 1\n\n[s]\nk = 1\n"), &sections)
+	c.Assert(err, ErrorMatches, "ini: unmarshal errors:\n"+
+		"  line 3: cannot unmarshal str `http` into int\n"+
+		"  cannot unmarshal str `http` into int")
+	c.Assert(err.(*ini.TypeError).Details[1].Line, Equals, 0)
+}
+
+var validationData = `
+name = app
+level = trace
+tags = web
+tags = API
+[server]
+port = 70000
+host = h
+timeout = 2m
+`
+
+func (s *S) TestUnmarshalValidation(c *C) {
+	var value struct {
+		Name   string   `ini:"name,required"`
+		Level  string   `ini:"level,oneof=debug|info|warn"`
+		Tags   []string `ini:"tags,min=1,regexp=^[a-z]+$"`
+		Server struct {
+			Port    int           `ini:"port,required,min=1,max=65535"`
+			Host    string        `ini:"host,min=3"`
+			Timeout time.Duration `ini:"timeout,max=1m"`
+			User    string        `ini:"user,required"`
+		}
+		DB struct {
+			URL string `ini:"url,required"`
+		} `ini:"db,required"`
+	}
+	err := ini.Unmarshal([]byte(validationData), &value)
+	c.Assert(err, ErrorMatches, "ini: validation errors:\n"+
+		"  section 'db' is required\n"+
+		"  key 'url' in section 'db' is required\n"+
+		"  line 3: key 'level' in section 'default' must be one of debug\\|info\\|warn\n"+
+		"  line 4: key 'tags' in section 'default' must match \\^\\[a-z\\]\\+\\$\n"+
+		"  line 6: key 'user' in section 'server' is required\n"+
+		"  line 7: key 'port' in section 'server' must be at most 65535\n"+
+		"  line 8: key 'host' in section 'server' must have a length of at least 3\n"+
+		"  line 9: key 'timeout' in section 'server' must be at most 1m")
+	c.Assert(value.Server.Port, Equals, 70000)
+
+	violations := err.(*ini.ValidationError).Violations
+	c.Assert(violations[5], Equals, ini.Violation{"server", "port", 7, "must be at most 65535"})
+
+	value.DB.URL = ""
+	err = ini.Unmarshal([]byte("name = a\nlevel = info\ntags = x\n[server]\nport = 80\nuser = u\n[db]\nurl = u\n"), &value)
+	c.Assert(err, IsNil)
+
+	// A missing key is reported at the line of its section.
+	err = ini.Unmarshal([]byte("\n[default]\nlevel = info\ntags = x\n[server]\nport = 80\nuser = u\n\n[db]\n"), &value)
+	c.Assert(err, ErrorMatches, "ini: validation errors:\n"+
+		"  line 2: key 'name' in section 'default' is required\n"+
+		"  line 9: key 'url' in section 'db' is required")
+}
+
+func (s *S) TestUnmarshalValidationTagError(c *C) {
+	var value struct {
+		Name string `ini:"name,regexp=("`
+	}
+	c.Assert(func() {
+		ini.Unmarshal([]byte("name = a"), &value)
+	}, PanicMatches, `Invalid flag "regexp=\(" in tag .*`)
+}
+
+func (s *S) TestUnmarshalSyntaxError(c *C) {
+	var value interface{}
+	err := ini.Unmarshal([]byte("a = 1\n[s]\nk = \"abc"), &value)
+	c.Assert(err, ErrorMatches, "ini: line 3: found unexpected end of stream")
+	var syntaxErr *ini.SyntaxError
+	c.Assert(errors.As(err, &syntaxErr), Equals, true)
+	c.Assert(syntaxErr.Line, Equals, 3)
+	c.Assert(syntaxErr.Column, Equals, 9)
+	c.Assert(syntaxErr.Offset, Equals, 18)
+	c.Assert(syntaxErr.Context, Equals, "while scanning a quoted scalar")
+	c.Assert(syntaxErr.Message, Equals, "found unexpected end of stream")
+}
+
+func (s *S) TestUnmarshalDefinitionError(c *C) {
+	dec := ini.NewDecoder(strings.NewReader("[s]\nk = 1\n[t]\n[s]\n"))
+	dec.SetDuplicatePolicy(ini.DuplicateError)
+	var value interface{}
+	err := dec.Decode(&value)
+	var defErr *ini.DefinitionError
+	c.Assert(errors.As(err, &defErr), Equals, true)
+	c.Assert(*defErr, DeepEquals, ini.DefinitionError{Name: "s", Line: 4, Other: 1, Message: "section 's' is already defined at line 1"})
+
+	_, err = ini.Load([]byte("[a:c]\n[b:a]\n[c:b]\n"))
+	c.Assert(errors.As(err, &defErr), Equals, true)
+	c.Assert(defErr.Name, Equals, "a")
+	c.Assert(defErr.Line, Equals, 1)
+	c.Assert(defErr.Other, Equals, 2)
+
+	err = ini.Unmarshal([]byte("a = 1\n[s]\n[t : s, u]\n"), &value)
+	c.Assert(errors.As(err, &defErr), Equals, true)
+	c.Assert(*defErr, DeepEquals, ini.DefinitionError{Name: "t", Line: 3, Message: `section "t" inherits undefined section "u"`})
+}
+
+func (s *S) TestUnmarshalDecodeError(c *C) {
+	var value struct {
+		Name   int
+		Server struct {
+			TLS struct{ Port int } `ini:"tls,flow"`
+		}
+	}
+	err := ini.Unmarshal([]byte("name = 1\n[server]\ntls.port =  https\n"), &value)
+	c.Assert(err, ErrorMatches, "ini: unmarshal errors:\n  line 3: cannot unmarshal str `https` into int")
+	var decodeErr *ini.DecodeError
+	c.Assert(errors.As(err, &decodeErr), Equals, true)
+	c.Assert(decodeErr, DeepEquals, &ini.DecodeError{
+		Section: "server",
+		KeyPath: []string{"tls", "port"},
+		Line:    3,
+		Column:  13,
+		Value:   "https",
+		Type:    reflect.TypeOf(0),
+		Message: "cannot unmarshal str `https` into int",
+	})
+}
+
+var interpolationTests = []struct {
+	data  string
+	value interface{}
+}{{
+	"host = localhost\nport = 8080\n[api]\nurl = http://${host}:${port}/api\nport = 9090\n[web:api]\nhost = example.com\nlink = ${api.url}\nraw = $${host}",
+	map[string]map[string]interface{}{
+		"api": {"host": "localhost", "port": 9090, "url": "http://localhost:9090/api"},
+		"web": {"host": "example.com", "port": 9090, "url": "http://example.com:9090/api", "link": "http://localhost:9090/api", "raw": "${host}"},
+	},
+}, {
+	"base = 80\nport = ${base}\nname = \"${base}\"\ndb.host = h\ndb.url = ${db.host}:${port}\n",
+	&struct {
+		Port int
+		Name string
+		DB   map[string]string `ini:"db,flow"`
+	}{80, "80", map[string]string{"host": "h", "url": "h:80"}},
+}, {
+	"[s]\nv = ${s.t.w}\n[s.t]\nw = x",
+	map[string]map[string]interface{}{"s": {"v": "x", "t": map[interface{}]interface{}{"w": "x"}}},
+}, {
+	"a = 1\na = 2\nb[] = x\nb[] = z\nr = ${a}\nq = ${b}",
+	map[string]interface{}{"a": 2, "b": []interface{}{"x", "z"}, "r": 2, "q": "z"},
+}}
+
+var interpolationErrorTests = []struct {
+	data, error string
+}{{
+	"a = ${s.b}\n[s]\nb = ${c}\nc = ${a}",
+	"ini: line 1: circular reference \\$\\{s.b\\} \\(line 3\\) -> \\$\\{c\\} \\(line 4\\) -> \\$\\{a\\} \\(line 1\\) -> \\$\\{s.b\\} \\(line 3\\)",
+}, {
+	"a = 1\nb = ${a}${missing}",
+	"ini: line 2: reference \\$\\{missing\\} is not defined",
+}}
+
+func (s *S) TestDecoderInterpolation(c *C) {
+	for _, item := range interpolationTests {
+		typ := reflect.ValueOf(item.value).Type()
+		var value interface{}
+		if typ.Kind() == reflect.Ptr {
+			value = reflect.New(typ.Elem()).Interface()
+		} else {
+			value = reflect.MakeMap(typ).Interface()
+		}
+		dec := ini.NewDecoder(strings.NewReader(item.data))
+		dec.SetInterpolation(true)
+		if err, ok := dec.Decode(value).(*ini.TypeError); !ok {
+			c.Assert(err, IsNil, Commentf("data: %q", item.data))
+		}
+		c.Assert(value, DeepEquals, item.value, Commentf("data: %q", item.data))
+	}
+	for _, item := range interpolationErrorTests {
+		dec := ini.NewDecoder(strings.NewReader(item.data))
+		dec.SetInterpolation(true)
+		var value interface{}
+		c.Assert(dec.Decode(&value), ErrorMatches, item.error)
+	}
+}
+
+func (s *S) TestDecoderInterpolationDepth(c *C) {
+	var data []string
+	for i := 0; i < 40; i++ {
+		data = append(data, fmt.Sprintf("k%d = ${k%d}", i, i+1))
+	}
+	data = append(data, "k40 = end")
+	dec := ini.NewDecoder(strings.NewReader(strings.Join(data, "\n")))
+	dec.SetInterpolation(true)
+	var value map[string]string
+	err := dec.Decode(&value)
+	c.Assert(err, ErrorMatches, "ini: line 33: references nested deeper than 32 levels")
+
+	value = nil
+	dec = ini.NewDecoder(strings.NewReader(strings.Join(data[20:], "\n")))
+	dec.SetInterpolation(true)
+	c.Assert(dec.Decode(&value), IsNil)
+	c.Assert(value["k20"], Equals, "end")
+}
+
+func (s *S) TestDecoder(c *C) {
+	for _, item := range unmarshalTests {
+		typ := reflect.ValueOf(item.value).Type()
+		var value interface{}
+		switch typ.Kind() {
+		case reflect.Map:
+			value = reflect.MakeMap(typ).Interface()
+		case reflect.String:
+			value = reflect.New(typ).Interface()
+		case reflect.Ptr:
+			value = reflect.New(typ.Elem()).Interface()
+		default:
+			c.Fatalf("missing case for %s", typ)
+		}
+		// Reading a byte at a time exercises the refilling of the buffers.
+		dec := ini.NewDecoder(iotest.OneByteReader(strings.NewReader(item.data)))
+		err := dec.Decode(value)
+		if _, ok := err.(*ini.TypeError); !ok {
+			c.Assert(err, IsNil)
+		}
+		if typ.Kind() == reflect.String {
+			c.Assert(*value.(*string), Equals, item.value)
+		} else {
+			c.Assert(value, DeepEquals, item.value)
+		}
+	}
+}
+
+func (s *S) TestDecoderEOF(c *C) {
+	dec := ini.NewDecoder(strings.NewReader("a = 1\n[s]\nb = 2\n"))
+	var value map[string]interface{}
+	c.Assert(dec.Decode(&value), IsNil)
+	c.Assert(value, DeepEquals, map[string]interface{}{"a": 1, "s": map[interface{}]interface{}{"a": 1, "b": 2}})
+	c.Assert(dec.Decode(&value), Equals, io.EOF)
+
+	dec = ini.NewDecoder(strings.NewReader(""))
+	value = nil
+	c.Assert(dec.Decode(&value), IsNil)
+	c.Assert(value, IsNil)
+	c.Assert(dec.Decode(&value), Equals, io.EOF)
+}
+
+func (s *S) TestDecoderErrors(c *C) {
+	for _, item := range unmarshalErrorTests {
+		var value interface{}
+		err := ini.NewDecoder(strings.NewReader(item.data)).Decode(&value)
+		c.Assert(err, ErrorMatches, item.error, Commentf("Partial unmarshal: %#v", value))
+	}
+}
+
+func (s *S) TestDecoderReadError(c *C) {
+	r := io.MultiReader(strings.NewReader("a = 1\n"), iotest.TimeoutReader(strings.NewReader("b = 2\n")))
+	var value interface{}
+	err := ini.NewDecoder(iotest.DataErrReader(r)).Decode(&value)
+	c.Assert(err, ErrorMatches, "ini: input error: timeout")
+}
+
+var envData = `home = ${env:HOME}
+port = ${env:PORT:-8080}
+user = ${env:USER:-nobody}
+dir = ${home}/.config
+`
+
+func (s *S) TestDecoderEnv(c *C) {
+	env := map[string]string{"HOME": "/home/gopher", "USER": ""}
+	dec := ini.NewDecoder(strings.NewReader(envData))
+	dec.SetInterpolation(true)
+	dec.SetLookupEnv(func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	var value map[string]interface{}
+	c.Assert(dec.Decode(&value), IsNil)
+	c.Assert(value, DeepEquals, map[string]interface{}{
+		"home": "/home/gopher",
+		"port": 8080,
+		"user": "nobody",
+		"dir":  "/home/gopher/.config",
+	})
+
+	value = nil
+	c.Assert(ini.Unmarshal([]byte(envData), &value), IsNil)
+	c.Assert(value["home"], Equals, "${env:HOME}")
+	c.Assert(value["dir"], Equals, "${home}/.config")
+
+	value = nil
+	dec = ini.NewDecoder(strings.NewReader(envData))
+	dec.SetLookupEnv(func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	})
+	c.Assert(dec.Decode(&value), IsNil)
+	c.Assert(value["home"], Equals, "/home/gopher")
+	c.Assert(value["dir"], Equals, "${home}/.config")
+
+	dec = ini.NewDecoder(strings.NewReader("a = 1\nb = ${env:GO_INI_UNSET_VARIABLE}\n"))
+	dec.ExpandEnv()
+	err := dec.Decode(&value)
+	c.Assert(err, ErrorMatches, "ini: line 2: environment variable GO_INI_UNSET_VARIABLE is not set")
+}
+
+func (s *S) TestDecoderDelimiter(c *C) {
+	dec := ini.NewDecoder(strings.NewReader("hosts = a, b , c\nname = x, y\n"))
+	dec.SetDelimiter(",")
+	var value struct {
+		Hosts []string
+		Ports []int
+		Name  string
+	}
+	c.Assert(dec.Decode(&value), IsNil)
+	c.Assert(value.Hosts, DeepEquals, []string{"a", "b", "c"})
+	c.Assert(value.Name, Equals, "x, y")
+
+	dec = ini.NewDecoder(strings.NewReader("ports = 80;443\n"))
+	dec.SetDelimiter(";")
+	c.Assert(dec.Decode(&value), IsNil)
+	c.Assert(value.Ports, DeepEquals, []int{80, 443})
+}
+
+var continuationTests = []struct {
+	data  string
+	value map[string]interface{}
+}{{
+	"sql = SELECT * \\\n  FROM t \\\r\n  WHERE a = 1\nnext = 1",
+	map[string]interface{}{"sql": "SELECT * FROM t WHERE a = 1", "next": 1},
+}, {
+	"pem =\n  -----BEGIN-----\n  MIIB==\n\t-----END-----\n\nnext = 1",
+	map[string]interface{}{"pem": "-----BEGIN-----\nMIIB==\n-----END-----", "next": 1},
+}, {
+	"v = a ; comment\n  b\n  ; comment\n  c = 1\nd = 2",
+	map[string]interface{}{"v": "a\nb\nc = 1", "d": 2},
+}, {
+	"v = a\n  b\n\n  c = 1",
+	map[string]interface{}{"v": "a\nb", "c": 1},
+}, {
+	"[s]\n\ta = 1\n\tb = 2\n\t\tmore\n\tc = 3\n",
+	map[string]interface{}{"s": map[interface{}]interface{}{"a": 1, "b": "2\nmore", "c": 3}},
+}, {
+	"[s]\nv = a\\\n\n[t]",
+	map[string]interface{}{"s": map[interface{}]interface{}{"v": "a"}, "t": map[interface{}]interface{}{}},
+}}
+
+func (s *S) TestDecoderContinuation(c *C) {
+	for _, item := range continuationTests {
+		dec := ini.NewDecoder(strings.NewReader(item.data))
+		dec.SetContinuation(true)
+		var value map[string]interface{}
+		c.Assert(dec.Decode(&value), IsNil, Commentf("data: %q", item.data))
+		c.Assert(value, DeepEquals, item.value, Commentf("data: %q", item.data))
+	}
+
+	f, err := ini.Load()
+	c.Assert(err, IsNil)
+	f.SetContinuation(true)
+	c.Assert(f.Append([]byte("# head\nv = a\n  b\n  # dropped\n  c\n# next\nw = 1\n")), IsNil)
+	c.Assert(f.Section("default").Key("v").String(), Equals, "a\nb\nc")
+	c.Assert(f.Section("default").Key("w").Comment(), Equals, "# next")
+}
+
+var unknownFieldsData = `
+name = app
+nmae = typo
+[server]
+host = localhost
+timout = 5s
+tls.cert = cert.pem
+tls.kye = key.pem
+[server.db]
+port = 5432
+prot = 5433
+[srever]
+host = typo
+[staging : server]
+`
+
+func (s *S) TestDecoderDisallowUnknownFields(c *C) {
+	var value struct {
+		Name   string
+		Server struct {
+			Host string
+			TLS  struct{ Cert string } `ini:"tls"`
+			DB   struct{ Port int }
+		}
+		Staging struct{ Host string }
+	}
+	c.Assert(ini.Unmarshal([]byte(unknownFieldsData), &value), IsNil)
+
+	dec := ini.NewDecoder(strings.NewReader(unknownFieldsData))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(&value)
+	c.Assert(err, FitsTypeOf, &ini.TypeError{})
+	c.Assert(err.(*ini.TypeError).Errors, DeepEquals, []string{
+		"line 3: unknown key 'nmae' in section 'default'",
+		"line 6: unknown key 'timout' in section 'server'",
+		"line 8: unknown key 'tls.kye' in section 'server'",
+		"line 11: unknown key 'prot' in section 'server.db'",
+		"line 12: unknown section 'srever'",
+	})
+	c.Assert(value.Server.TLS.Cert, Equals, "cert.pem")
+	c.Assert(value.Staging.Host, Equals, "localhost")
+
+	var m map[string]interface{}
+	dec = ini.NewDecoder(strings.NewReader(unknownFieldsData))
+	dec.DisallowUnknownFields()
+	c.Assert(dec.Decode(&m), IsNil)
+}
+
+var caseData = `
+[Common]
+iNt = 8080
+Host = localhost
+host = example.com
+[Dev : COMMON]
+FLOAT = 1.5
+[common.TLS]
+Cert = cert.pem
+`
+
+func (s *S) TestDecoderCaseInsensitive(c *C) {
+	type Section struct {
+		Int   int
+		Host  string
+		Float float64
+		TLS   struct{ Cert string } `ini:"tls"`
+	}
+	var value struct {
+		Common Section
+		Dev    Section
+	}
+	dec := ini.NewDecoder(strings.NewReader(caseData))
+	dec.SetCaseInsensitive(true)
+	c.Assert(dec.Decode(&value), IsNil)
+	c.Assert(value.Common.Int, Equals, 8080)
+	c.Assert(value.Common.Host, Equals, "example.com")
+	c.Assert(value.Common.TLS.Cert, Equals, "cert.pem")
+	c.Assert(value.Dev.Int, Equals, 8080)
+	c.Assert(value.Dev.Float, Equals, 1.5)
+
+	err := ini.Unmarshal([]byte(caseData), &value)
+	c.Assert(err, ErrorMatches, `ini: line 6: section "Dev" inherits undefined section "COMMON"`)
+}
+
+func (s *S) TestDecoderCaseInsensitiveDefault(c *C) {
+	data := "[DEFAULT]\nname = app\n[s]\nport = 80\n[t : Default]\nhost = x\n"
+	dec := ini.NewDecoder(strings.NewReader(data))
+	dec.SetCaseInsensitive(true)
+	var value map[string]interface{}
+	c.Assert(dec.Decode(&value), IsNil)
+	c.Assert(value, DeepEquals, map[string]interface{}{
+		"name": "app",
+		"s":    map[interface{}]interface{}{"name": "app", "port": 80},
+		"t":    map[interface{}]interface{}{"name": "app", "host": "x"},
+	})
+
+	var st struct {
+		Name string
+		S    struct{ Name string }
+	}
+	dec = ini.NewDecoder(strings.NewReader(data))
+	dec.SetCaseInsensitive(true)
+	c.Assert(dec.Decode(&st), IsNil)
+	c.Assert(st.Name, Equals, "app")
+	c.Assert(st.S.Name, Equals, "app")
+}
+
+var duplicateData = `
+a = 1
+a = 2
+list[] = u
+list[] = v
+[s]
+k = 1
+[s]
+k = 2
+z = 3
+`
+
+func (s *S) TestDecoderDuplicatePolicy(c *C) {
+	for _, item := range []struct {
+		policy ini.DuplicatePolicy
+		value  map[string]interface{}
+	}{{
+		ini.DuplicateLastWins,
+		map[string]interface{}{"a": 2, "list": []interface{}{"u", "v"}, "s": map[interface{}]interface{}{"k": 2, "z": 3}},
+	}, {
+		ini.DuplicateFirstWins,
+		map[string]interface{}{"a": 1, "list": []interface{}{"u", "v"}, "s": map[interface{}]interface{}{"k": 1}},
+	}, {
+		ini.DuplicateAccumulate,
+		map[string]interface{}{"a": []interface{}{1, 2}, "list": []interface{}{"u", "v"}, "s": map[interface{}]interface{}{"k": []interface{}{1, 2}, "z": 3}},
+	}} {
+		dec := ini.NewDecoder(strings.NewReader(duplicateData))
+		dec.SetDuplicatePolicy(item.policy)
+		var value map[string]interface{}
+		c.Assert(dec.Decode(&value), IsNil)
+		delete(value["s"].(map[interface{}]interface{}), "a")
+		delete(value["s"].(map[interface{}]interface{}), "list")
+		c.Assert(value, DeepEquals, item.value)
+	}
+
+	for _, item := range []struct{ data, error string }{
+		{duplicateData, "ini: line 3: key 'a' is already defined at line 2"},
+		{"[s]\nk = 1\n[t]\n[s]\n", "ini: line 4: section 's' is already defined at line 1"},
+		{"a.b = 1\na.b.c = 2\n", "ini: line 2: key 'b' is already defined at line 1"},
+		{"list[] = x\nlist[] = y\n", ""},
+	} {
+		dec := ini.NewDecoder(strings.NewReader(item.data))
+		dec.SetDuplicatePolicy(ini.DuplicateError)
+		var value map[string]interface{}
+		err := dec.Decode(&value)
+		if item.error == "" {
+			c.Assert(err, IsNil)
+		} else {
+			c.Assert(err, ErrorMatches, item.error)
+		}
+	}
+}
+
+var tolerantData = `a = 1
+hello: world
+b = 2
+[s]
+k = 'x
+j = 3
+[t u]
+v = 4
+[w : ]
+x = "\q"
+z = 5
+= 6
+`
+
+func (s *S) TestDecoderTolerant(c *C) {
+	dec := ini.NewDecoder(strings.NewReader(tolerantData))
+	dec.SetTolerant(true)
+	var value map[string]interface{}
+	err := dec.Decode(&value)
+	c.Assert(err, ErrorMatches, "ini: syntax errors:\n"+
+		"  line 2: did not find expected <value> or <map>\n"+
+		"  line 5: found unexpected line break\n"+
+		"  line 7: found a blank inside the section key\n"+
+		"  line 9: found an empty inherited section name\n"+
+		"  line 10: found unknown escape character\n"+
+		"  line 12: did not find expected <key> or <section-start>")
+	c.Assert(value, DeepEquals, map[string]interface{}{
+		"a": 1,
+		"b": 2,
+		"s": map[interface{}]interface{}{"a": 1, "b": 2, "j": 3},
+		"w": map[interface{}]interface{}{"a": 1, "b": 2},
+	})
+
+	var syntaxErr *ini.SyntaxError
+	c.Assert(errors.As(err, &syntaxErr), Equals, true)
+	c.Assert(*syntaxErr, DeepEquals, ini.SyntaxError{Line: 2, Column: 13, Offset: 18, Message: "did not find expected <value> or <map>"})
+	syntaxErr = err.(*ini.ParseError).Errors[1]
+	c.Assert(syntaxErr.Line, Equals, 5)
+	c.Assert(syntaxErr.Column, Equals, 7)
+	c.Assert(syntaxErr.Context, Equals, "while scanning a quoted scalar")
+
+	value = nil
+	err = ini.Unmarshal([]byte(tolerantData), &value)
+	c.Assert(err, ErrorMatches, "ini: line 2: did not find expected <value> or <map>")
+}
+
+var brokenHeaderTests = []struct {
+	data   string
+	errors int
+	value  map[string]interface{}
+}{
+	{"[b@d]\nk = 2\n[ok]\nm = 3\n", 1, map[string]interface{}{
+		"ok": map[interface{}]interface{}{"m": 3},
+	}},
+	{"a = 1\n[b@d]\nk = 2\n[ok]\nm = 3\n", 1, map[string]interface{}{
+		"a":  1,
+		"ok": map[interface{}]interface{}{"a": 1, "m": 3},
+	}},
+	{"[s]\nj = 1\n[t u]\nk = 'x\nl = 2\n", 2, map[string]interface{}{
+		"s": map[interface{}]interface{}{"j": 1},
+	}},
+}
+
+func (s *S) TestDecoderTolerantBrokenHeader(c *C) {
+	for i, item := range brokenHeaderTests {
+		c.Logf("test %d: %q", i, item.data)
+		dec := ini.NewDecoder(strings.NewReader(item.data))
+		dec.SetTolerant(true)
+		var value map[string]interface{}
+		err := dec.Decode(&value)
+		c.Assert(err, FitsTypeOf, &ini.ParseError{})
+		c.Assert(err.(*ini.ParseError).Errors, HasLen, item.errors)
+		c.Assert(value, DeepEquals, item.value)
+	}
+}
+
+var permissiveNamesData = `
+[Network Settings]
+host = localhost
+[日本語]
+key = value
+[a:b, c]
+x = 1
+[Remote "origin"]
+url = git
+`
+
+func (s *S) TestDecoderNameGrammar(c *C) {
+	dec := ini.NewDecoder(strings.NewReader(permissiveNamesData))
+	dec.SetNameGrammar(ini.PermissiveNames)
+	var value map[string]interface{}
+	c.Assert(dec.Decode(&value), IsNil)
+	c.Assert(value, DeepEquals, map[string]interface{}{
+		"Network Settings": map[interface{}]interface{}{"host": "localhost"},
+		"日本語":              map[interface{}]interface{}{"key": "value"},
+		"a:b, c":           map[interface{}]interface{}{"x": 1},
+		"Remote":           map[interface{}]interface{}{"origin": map[interface{}]interface{}{"url": "git"}},
+	})
+
+	value = nil
+	err := ini.Unmarshal([]byte(permissiveNamesData), &value)
+	c.Assert(err, ErrorMatches, "ini: line 2: found a blank inside the section key")
+}
+
 var unmarshalerTests = []struct {
 	data  string
 	value interface{}
@@ -1,6 +1,8 @@
 package ini
 
-import ()
+import (
+	"bytes"
+)
 
 // Flush the buffer if needed.
 func flush(emitter *ini_emitter_t) bool {
@@ -123,75 +125,40 @@ func ini_emitter_emit(emitter *ini_emitter_t, event *ini_event_t) bool {
 
 // Check if we need to accumulate more events before emitting.
 //
-// We accumulate extra
-//  - 1 event for DOCUMENT-START
-//  - 2 events for SEQUENCE-START
-//  - 3 events for MAPPING-START
-//
+// The INI grammar never needs to look ahead: a section header is closed
+// by whatever event follows it, so every queued event may be emitted
+// right away.
 func ini_emitter_need_more_events(emitter *ini_emitter_t) bool {
-	if emitter.events_head == len(emitter.events) {
-		return true
-	}
-	var accumulate int
-	switch emitter.events[emitter.events_head].typ {
-	case ini_DOCUMENT_START_EVENT:
-		accumulate = 1
-		break
-	case ini_MAPPING_EVENT:
-		accumulate = 2
-		break
-	case ini_COMMENT_EVENT:
-		accumulate = 3
-		break
-	default:
-		return false
-	}
-	if len(emitter.events)-emitter.events_head > accumulate {
-		return false
-	}
-	var level int
-	for i := emitter.events_head; i < len(emitter.events); i++ {
-		switch emitter.events[i].typ {
-		case ini_DOCUMENT_START_EVENT, ini_MAPPING_EVENT:
-			level++
-		}
-		if level == 0 {
-			return false
-		}
-	}
-	return true
+	return emitter.events_head == len(emitter.events)
 }
 
 // State dispatcher.
 func ini_emitter_state_machine(emitter *ini_emitter_t, event *ini_event_t) bool {
 	switch emitter.state {
-	default:
 	case ini_EMIT_DOCUMENT_START_STATE:
-		return ini_emitter_emit_start(emitter, event)
-	case ini_EMIT_DOCUMENT_END_STATE:
-		return ini_emitter_emit_document(emitter, event)
+		return ini_emitter_emit_document_start(emitter, event)
 	case ini_EMIT_FIRST_SECTION_START_STATE:
 		return ini_emitter_emit_section_start(emitter, event, true)
-	case ini_EMIT_SECTION_START_STATE:
-		return ini_emitter_emit_section_start(emitter, event, false)
-	case ini_EMIT_SECTION_FIRST_NODE_KEY_STATE:
-		return ini_emitter_emit_node(emitter, event)
+	case ini_EMIT_SECTION_INHERIT_STATE:
+		return ini_emitter_emit_section_inherit(emitter, event, true)
+	case ini_EMIT_SECTION_INHERIT_MORE_STATE:
+		return ini_emitter_emit_section_inherit(emitter, event, false)
 	case ini_EMIT_ELEMENT_KEY_STATE:
-		return ini_emitter_emit_node(emitter, event)
+		return ini_emitter_emit_key(emitter, event, false)
+	case ini_EMIT_MAPPING_KEY_STATE:
+		return ini_emitter_emit_key(emitter, event, true)
 	case ini_EMIT_ELEMENT_VALUE_STATE:
-		return ini_emitter_emit_node(emitter, event)
-	case ini_EMIT_SECTION_END_STATE:
-		return ini_emitter_emit_section_end(emitter, event, false)
-	case ini_EMIT_COMMENT_START_STATE:
-		return ini_emitter_emit_comment(emitter, event)
+		return ini_emitter_emit_value(emitter, event)
+	case ini_EMIT_DOCUMENT_END_STATE:
+		return ini_emitter_set_emitter_error(emitter, "expected nothing after DOCUMENT-END")
 	}
 	panic("invalid emitter state")
 }
 
 // Expect DOCUMENT-START.
-func ini_emitter_emit_start(emitter *ini_emitter_t, event *ini_event_t) bool {
+func ini_emitter_emit_document_start(emitter *ini_emitter_t, event *ini_event_t) bool {
 	if event.typ != ini_DOCUMENT_START_EVENT {
-		return ini_emitter_set_emitter_error(emitter, "expected START")
+		return ini_emitter_set_emitter_error(emitter, "expected DOCUMENT-START")
 	}
 	if emitter.line_break == ini_ANY_BREAK {
 		emitter.line_break = ini_LN_BREAK
@@ -200,128 +167,317 @@ func ini_emitter_emit_start(emitter *ini_emitter_t, event *ini_event_t) bool {
 	emitter.line = 0
 	emitter.column = 0
 	emitter.whitespace = true
+	emitter.opened = true
 
-	emitter.state = ini_EMIT_DOCUMENT_END_STATE
+	emitter.state = ini_EMIT_FIRST_SECTION_START_STATE
 	return true
 }
 
-func ini_emitter_emit_document(emitter *ini_emitter_t, event *ini_event_t) bool {
-	if event.typ != ini_DOCUMENT_START_EVENT {
-		return ini_emitter_set_emitter_error(emitter, "expected DOCUMENT-START")
+// Expect DOCUMENT-END.
+func ini_emitter_emit_document_end(emitter *ini_emitter_t, event *ini_event_t) bool {
+	if len(event.foot_comment) > 0 {
+		if emitter.line > 0 || emitter.column > 0 {
+			if !ini_emitter_write_eol(emitter) {
+				return false
+			}
+		}
+		if !ini_emitter_write_head_comment(emitter, event.foot_comment) {
+			return false
+		}
 	}
-	if emitter.line_break == ini_ANY_BREAK {
-		emitter.line_break = ini_LN_BREAK
+	if !ini_emitter_flush(emitter) {
+		return false
 	}
-
-	emitter.line = 0
-	emitter.whitespace = true
-
-	emitter.state = ini_EMIT_FIRST_SECTION_START_STATE
+	emitter.closed = true
+	emitter.state = ini_EMIT_DOCUMENT_END_STATE
 	return true
 }
 
-// Expect a section start.
+// Expect SECTION-ENTRY.
+//
+// The header of the default section is omitted when it is the first
+// section of the document, as the parser puts leading keys there anyway.
 func ini_emitter_emit_section_start(emitter *ini_emitter_t, event *ini_event_t, first bool) bool {
-	if first == true {
-		if string(emitter.scalar_data.value) == DEFAULT_SECTION {
-
+	if first {
+		switch event.typ {
+		case ini_SCALAR_EVENT:
+			return ini_emitter_emit_key(emitter, event, false)
+		case ini_DOCUMENT_END_EVENT:
+			return ini_emitter_emit_document_end(emitter, event)
 		}
-	} else {
-		if !ini_emitter_write_indicator(emitter, []byte{'['}, false, false) {
+	}
+	if event.typ != ini_SECTION_ENTRY_EVENT {
+		return ini_emitter_set_emitter_error(emitter, "expected SECTION-ENTRY, SCALAR or DOCUMENT-END")
+	}
+	if first && string(event.value) == DEFAULT_SECTION {
+		if !ini_emitter_write_head_comment(emitter, event.head_comment) {
+			return false
+		}
+		emitter.state = ini_EMIT_ELEMENT_KEY_STATE
+		return true
+	}
+	if len(event.value) == 0 {
+		return ini_emitter_set_emitter_error(emitter, "section name must not be empty")
+	}
+	if !ini_emitter_check_section_name(emitter, event.value) {
+		return ini_emitter_set_emitter_error(emitter, "section name contains characters that cannot be scanned back")
+	}
+	if emitter.line > 0 || emitter.column > 0 {
+		if !ini_emitter_write_eol(emitter) {
 			return false
 		}
-		if !ini_emitter_process_element(emitter) {
+	}
+	if !ini_emitter_write_head_comment(emitter, event.head_comment) {
+		return false
+	}
+	if !ini_emitter_write_indicator(emitter, []byte{'['}, false, true) {
+		return false
+	}
+	if !write_all(emitter, event.value) {
+		return false
+	}
+	emitter.whitespace = false
+	emitter.line_comment = event.line_comment
+	emitter.state = ini_EMIT_SECTION_INHERIT_STATE
+	return true
+}
+
+// Check that a section name may be scanned back: it is made of dotted
+// segments of alphanumerical characters, '_' and '-', or of anything but
+// ']' with permissive names, and may end with a quoted subsection.
+func ini_emitter_check_section_name(emitter *ini_emitter_t, value []byte) bool {
+	base := value
+	if i := bytes.Index(value, []byte(` "`)); i >= 0 {
+		if !ini_emitter_check_subsection(value[i+1:]) {
 			return false
 		}
-		if !ini_emitter_write_indicator(emitter, []byte{']'}, false, false) {
+		base = value[:i]
+	}
+	if len(base) == 0 {
+		return false
+	}
+	if emitter.permissive_names {
+		if is_blank(base, 0) || is_blank(base, len(base)-1) {
 			return false
 		}
+		for i := 0; i < len(base); i += width(base[i]) {
+			if base[i] == ']' || is_break(base, i) {
+				return false
+			}
+		}
+		return true
 	}
-	emitter.states = append(emitter.states, ini_EMIT_SECTION_START_STATE)
-	return ini_emitter_emit_node(emitter, event)
+	for _, segment := range bytes.Split(base, []byte{'.'}) {
+		if len(segment) == 0 {
+			return false
+		}
+		for i := range segment {
+			if !is_alpha(segment, i) {
+				return false
+			}
+		}
+	}
+	return true
 }
 
-// Expect a section end.
-func ini_emitter_emit_section_end(emitter *ini_emitter_t, event *ini_event_t, first bool) bool {
-	emitter.states = append(emitter.states, ini_EMIT_SECTION_END_STATE)
-	return ini_emitter_emit_node(emitter, event)
+// Check that a quoted subsection is closed at its end and escapes every
+// '"' and '\\' it holds.
+func ini_emitter_check_subsection(value []byte) bool {
+	if len(value) < 2 || value[len(value)-1] != '"' {
+		return false
+	}
+	value = value[1 : len(value)-1]
+	for i := 0; i < len(value); i += width(value[i]) {
+		switch {
+		case value[i] == '\\':
+			i++
+			if i == len(value) || is_break(value, i) {
+				return false
+			}
+		case value[i] == '"' || is_break(value, i):
+			return false
+		}
+	}
+	return true
 }
 
-// Expect a node.
-func ini_emitter_emit_node(emitter *ini_emitter_t, event *ini_event_t) bool {
-	switch event.typ {
-	case ini_SCALAR_EVENT:
-		return ini_emitter_emit_scalar(emitter, event)
-	case ini_COMMENT_EVENT:
-		return ini_emitter_emit_comment(emitter, event)
-	default:
-		return ini_emitter_set_emitter_error(emitter,
-			"expected SCALAR, SECTION-START, COMMENT-START")
+// Expect SECTION-INHERIT, or close the section header and hand the event
+// over to the key state.
+func ini_emitter_emit_section_inherit(emitter *ini_emitter_t, event *ini_event_t, first bool) bool {
+	if event.typ == ini_SECTION_INHERIT_EVENT {
+		if emitter.permissive_names {
+			return ini_emitter_set_emitter_error(emitter, "sections cannot inherit others with permissive names")
+		}
+		if len(event.value) == 0 {
+			return ini_emitter_set_emitter_error(emitter, "inherited section name must not be empty")
+		}
+		if !ini_emitter_check_section_name(emitter, event.value) {
+			return ini_emitter_set_emitter_error(emitter, "inherited section name contains characters that cannot be scanned back")
+		}
+		indicator := []byte{':'}
+		if !first {
+			indicator = []byte(", ")
+		}
+		if !ini_emitter_write_indicator(emitter, indicator, false, false) {
+			return false
+		}
+		if !write_all(emitter, event.value) {
+			return false
+		}
+		emitter.state = ini_EMIT_SECTION_INHERIT_MORE_STATE
+		return true
 	}
-	return false
+	if !ini_emitter_write_indicator(emitter, []byte{']'}, false, false) {
+		return false
+	}
+	if !ini_emitter_write_line_comment(emitter, emitter.line_comment) {
+		return false
+	}
+	emitter.line_comment = nil
+	if !ini_emitter_write_eol(emitter) {
+		return false
+	}
+	emitter.state = ini_EMIT_ELEMENT_KEY_STATE
+	return ini_emitter_emit_key(emitter, event, false)
 }
 
-// Expect a comment.
-func ini_emitter_emit_comment(emitter *ini_emitter_t, event *ini_event_t) bool {
-	if !ini_emitter_write_indicator(emitter, []byte{'#'}, false, false) {
+// Expect a key.
+//
+// Outside of a MAPPING a new section or the end of the document may start
+// instead.
+func ini_emitter_emit_key(emitter *ini_emitter_t, event *ini_event_t, mapping bool) bool {
+	if !mapping {
+		switch event.typ {
+		case ini_SECTION_ENTRY_EVENT:
+			return ini_emitter_emit_section_start(emitter, event, false)
+		case ini_DOCUMENT_END_EVENT:
+			return ini_emitter_emit_document_end(emitter, event)
+		}
+	}
+	if event.typ != ini_SCALAR_EVENT {
+		return ini_emitter_set_emitter_error(emitter, "expected SCALAR key")
+	}
+	if !mapping && !ini_emitter_write_head_comment(emitter, event.head_comment) {
 		return false
 	}
+	if !ini_emitter_analyze_scalar(emitter, event.value) {
+		return false
+	}
+	for _, c := range event.value {
+		if c == '.' {
+			return ini_emitter_set_emitter_error(emitter, "key contains '.' which would be scanned as a map key")
+		}
+	}
+	if !ini_emitter_select_scalar_style(emitter, event) {
+		return false
+	}
+	if mapping && emitter.scalar_data.style != ini_PLAIN_SCALAR_STYLE {
+		return ini_emitter_set_emitter_error(emitter, "dotted key segment cannot be written plain")
+	}
 	if !ini_emitter_process_element(emitter) {
 		return false
 	}
-	return false
+	emitter.state = ini_EMIT_ELEMENT_VALUE_STATE
+	return true
 }
 
-// Expect SCALAR.
-func ini_emitter_emit_scalar(emitter *ini_emitter_t, event *ini_event_t) bool {
+// Expect MAPPING or the value of a key.
+func ini_emitter_emit_value(emitter *ini_emitter_t, event *ini_event_t) bool {
+	switch event.typ {
+	case ini_MAPPING_EVENT:
+		// The scanner only splits plain keys apart.
+		if emitter.scalar_data.style != ini_PLAIN_SCALAR_STYLE {
+			return ini_emitter_set_emitter_error(emitter, "dotted key segment cannot be written plain")
+		}
+		if !ini_emitter_write_indicator(emitter, []byte{'.'}, false, true) {
+			return false
+		}
+		emitter.state = ini_EMIT_MAPPING_KEY_STATE
+		return true
+	case ini_SCALAR_EVENT:
+	default:
+		return ini_emitter_set_emitter_error(emitter, "expected MAPPING or SCALAR value")
+	}
+	if !ini_emitter_write_indicator(emitter, []byte{'='}, true, false) {
+		return false
+	}
+	if !ini_emitter_analyze_scalar(emitter, event.value) {
+		return false
+	}
 	if !ini_emitter_select_scalar_style(emitter, event) {
 		return false
 	}
 	if !ini_emitter_process_element(emitter) {
 		return false
 	}
-	emitter.state = emitter.states[len(emitter.states)-1]
-	emitter.states = emitter.states[:len(emitter.states)-1]
+	if !ini_emitter_write_line_comment(emitter, event.line_comment) {
+		return false
+	}
+	if !ini_emitter_write_eol(emitter) {
+		return false
+	}
+	emitter.state = ini_EMIT_ELEMENT_KEY_STATE
 	return true
 }
 
-// Check if the document content is an empty scalar.
-func ini_emitter_check_empty_document(emitter *ini_emitter_t) bool {
-	return false // [Go] Huh?
-}
+// Check if a scalar can be written back in each of the scalar styles.
+func ini_emitter_analyze_scalar(emitter *ini_emitter_t, value []byte) bool {
+	emitter.scalar_data.value = value
+	emitter.scalar_data.multiline = false
+	emitter.scalar_data.plain_allowed = true
+	emitter.scalar_data.single_quoted_allowed = true
 
-// Check if the next events represent an empty section.
-func ini_emitter_check_empty_section(emitter *ini_emitter_t) bool {
-	if len(emitter.events)-emitter.events_head < 2 {
-		return false
-	}
-	if emitter.events[emitter.events_head].typ == ini_MAPPING_EVENT &&
-			(emitter.events[emitter.events_head+1].typ == ini_MAPPING_EVENT ||  emitter.events[emitter.events_head+2].typ == ini_MAPPING_EVENT) {
+	if len(value) == 0 {
+		emitter.scalar_data.plain_allowed = false
 		return true
 	}
-	return false
 
-}
-
-// Check if the next events represent an empty comment.
-func ini_emitter_check_empty_comment(emitter *ini_emitter_t) bool {
-	if len(emitter.events)-emitter.events_head < 2 {
-		return false
+	// The scanner trims blanks around plain scalars and treats these
+	// leading indicators as quotes, comments or section markers.
+	if is_blank(value, 0) || is_blank(value, len(value)-1) {
+		emitter.scalar_data.plain_allowed = false
+	}
+	switch value[0] {
+	case '\'', '"', '#', ';', '[', ']', ':':
+		emitter.scalar_data.plain_allowed = false
+	}
+	// A '\\' ending the scalar would join the next line to it when read
+	// with continuation lines.
+	if value[len(value)-1] == '\\' {
+		emitter.scalar_data.plain_allowed = false
+	}
+
+	for i := 0; i < len(value); i += width(value[i]) {
+		switch {
+		case is_blank(value, i) && i+1 < len(value) && (value[i+1] == '#' || value[i+1] == ';'):
+			// It would be scanned as the start of a comment.
+			emitter.scalar_data.plain_allowed = false
+		case is_break(value, i):
+			emitter.scalar_data.multiline = true
+			emitter.scalar_data.plain_allowed = false
+			emitter.scalar_data.single_quoted_allowed = false
+		case !is_printable(value, i) || !is_ascii(value, i) && !emitter.unicode:
+			emitter.scalar_data.plain_allowed = false
+			emitter.scalar_data.single_quoted_allowed = false
+		case value[i] == '=':
+			emitter.scalar_data.plain_allowed = false
+		case value[i] == '\'':
+			emitter.scalar_data.single_quoted_allowed = false
+		}
 	}
-	return emitter.events[emitter.events_head].typ == ini_COMMENT_EVENT
+	return true
 }
 
 // Determine an acceptable scalar style.
 func ini_emitter_select_scalar_style(emitter *ini_emitter_t, event *ini_event_t) bool {
-
 	style := event.scalar_style()
 	if style == ini_ANY_SCALAR_STYLE {
 		style = ini_PLAIN_SCALAR_STYLE
 	}
 
 	if style == ini_PLAIN_SCALAR_STYLE {
-		if len(emitter.scalar_data.value) == 0 {
-			style = ini_SINGLE_QUOTED_SCALAR_STYLE
+		if !emitter.scalar_data.plain_allowed {
+			style = ini_DOUBLE_QUOTED_SCALAR_STYLE
 		}
 	}
 	if style == ini_SINGLE_QUOTED_SCALAR_STYLE {
@@ -337,6 +493,9 @@ func ini_emitter_select_scalar_style(emitter *ini_emitter_t, event *ini_event_t)
 // Write a scalar.
 func ini_emitter_process_element(emitter *ini_emitter_t) bool {
 	switch emitter.scalar_data.style {
+	case ini_PLAIN_SCALAR_STYLE:
+		return ini_emitter_write_plain_element(emitter, emitter.scalar_data.value)
+
 	case ini_SINGLE_QUOTED_SCALAR_STYLE:
 		return ini_emitter_write_single_quoted_element(emitter, emitter.scalar_data.value)
 
@@ -346,6 +505,39 @@ func ini_emitter_process_element(emitter *ini_emitter_t) bool {
 	panic("unknown scalar style")
 }
 
+// Terminate the current line.
+func ini_emitter_write_eol(emitter *ini_emitter_t) bool {
+	if !put_break(emitter) {
+		return false
+	}
+	emitter.whitespace = true
+	return true
+}
+
+// Write the lines of a head or foot comment, each on a line of its own.
+func ini_emitter_write_head_comment(emitter *ini_emitter_t, comment []byte) bool {
+	if len(comment) == 0 {
+		return true
+	}
+	for _, line := range bytes.Split(comment, []byte{'\n'}) {
+		if !write_all(emitter, line) {
+			return false
+		}
+		if !ini_emitter_write_eol(emitter) {
+			return false
+		}
+	}
+	return true
+}
+
+// Write a comment at the end of the current line.
+func ini_emitter_write_line_comment(emitter *ini_emitter_t, comment []byte) bool {
+	if len(comment) == 0 {
+		return true
+	}
+	return ini_emitter_write_indicator(emitter, comment, true, false)
+}
+
 // Write the BOM character.
 func ini_emitter_write_bom(emitter *ini_emitter_t) bool {
 	if !flush(emitter) {
@@ -373,6 +565,19 @@ func ini_emitter_write_indicator(emitter *ini_emitter_t, indicator []byte, need_
 	return true
 }
 
+func ini_emitter_write_plain_element(emitter *ini_emitter_t, value []byte) bool {
+	if !emitter.whitespace {
+		if !put(emitter, ' ') {
+			return false
+		}
+	}
+	if !write_all(emitter, value) {
+		return false
+	}
+	emitter.whitespace = false
+	return true
+}
+
 func ini_emitter_write_single_quoted_element(emitter *ini_emitter_t, value []byte) bool {
 
 	if !ini_emitter_write_indicator(emitter, []byte{'\''}, true, false) {
@@ -2,34 +2,87 @@ package ini
 
 import (
 	"encoding"
+	"fmt"
+	"io"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 )
 
 type encoder struct {
-	emitter ini_emitter_t
-	event   ini_event_t
-	out     []byte
-	flow    bool
+	emitter  ini_emitter_t
+	event    ini_event_t
+	out      []byte
+	flow     bool
+	level    int
+	doneInit bool
+
+	// path holds the keys of the maps being flattened into dotted keys.
+	path []reflect.Value
+
+	// maxDepth limits the number of segments of a dotted key, or is
+	// zero for no limit.
+	maxDepth int
+
+	// section is the name of the section being encoded.
+	section string
+
+	// headComment and lineComment are attached to the next scalar.
+	headComment []byte
+	lineComment []byte
+
+	// arrayStyle and delimiter select how slices and arrays are written.
+	arrayStyle ArrayStyle
+	delimiter  string
 }
 
-func newEncoder() (e *encoder) {
-	e = &encoder{}
+var mapSliceType = reflect.TypeOf(MapSlice{})
+
+func newEncoder() *encoder {
+	e := &encoder{}
 	e.must(ini_emitter_initialize(&e.emitter))
 	ini_emitter_set_output_string(&e.emitter, &e.out)
 	ini_emitter_set_unicode(&e.emitter, true)
+	return e
+}
+
+func newEncoderWithWriter(w io.Writer) *encoder {
+	e := &encoder{}
+	e.must(ini_emitter_initialize(&e.emitter))
+	ini_emitter_set_output_writer(&e.emitter, w)
+	ini_emitter_set_unicode(&e.emitter, true)
+	return e
+}
+
+// init starts the document. It is deferred until the first value is
+// encoded so that the emitter settings may still be changed after the
+// encoder is created.
+func (e *encoder) init() {
+	if e.doneInit {
+		return
+	}
 	e.must(ini_document_start_event_initialize(&e.event))
 	e.emit()
-	return e
+	e.doneInit = true
 }
 
 func (e *encoder) finish() {
-	e.must(ini_document_start_event_initialize(&e.event))
+	e.must(ini_document_end_event_initialize(&e.event))
 	e.emit()
-	e.emitter.open_ended = false
+}
+
+// marshalDoc encodes in as a whole document.
+func (e *encoder) marshalDoc(in reflect.Value) {
+	e.init()
+	if f, ok := fileOf(in); ok {
+		e.documentNode(f.doc)
+		return
+	}
+	e.marshal(in)
+	e.finish()
 }
 
 func (e *encoder) destroy() {
@@ -38,9 +91,7 @@ func (e *encoder) destroy() {
 
 func (e *encoder) emit() {
 	// This will internally delete the e.event value.
-	if !ini_emitter_emit(&e.emitter, &e.event) && e.event.typ != ini_DOCUMENT_END_EVENT {
-		e.must(false)
-	}
+	e.must(ini_emitter_emit(&e.emitter, &e.event))
 }
 
 func (e *encoder) must(ok bool) {
@@ -53,49 +104,63 @@ func (e *encoder) must(ok bool) {
 	}
 }
 
+// e.prepare dereferences pointers and interfaces and calls MarshalINI or
+// MarshalText if a value is found to implement them.
+// It returns the value to be encoded, which is invalid for nil values.
+func (e *encoder) prepare(in reflect.Value) reflect.Value {
+	for in.IsValid() {
+		if (in.Kind() == reflect.Ptr || in.Kind() == reflect.Interface) && in.IsNil() {
+			return zeroValue
+		}
+		iface := in.Interface()
+		if m, ok := iface.(Marshaler); ok {
+			v, err := m.MarshalINI()
+			if err != nil {
+				fail(err)
+			}
+			in = reflect.ValueOf(v)
+			continue
+		}
+		if m, ok := iface.(encoding.TextMarshaler); ok {
+			text, err := m.MarshalText()
+			if err != nil {
+				fail(err)
+			}
+			return reflect.ValueOf(string(text))
+		}
+		if in.Kind() != reflect.Ptr && in.Kind() != reflect.Interface {
+			break
+		}
+		in = in.Elem()
+	}
+	return in
+}
+
 func (e *encoder) marshal(in reflect.Value) {
+	in = e.prepare(in)
 	if !in.IsValid() {
 		e.nilv()
 		return
 	}
-	iface := in.Interface()
-	if m, ok := iface.(Marshaler); ok {
-		v, err := m.MarshalINI()
-		if err != nil {
-			fail(err)
-		}
-		if v == nil {
-			e.nilv()
-			return
-		}
-		in = reflect.ValueOf(v)
-	} else if m, ok := iface.(encoding.TextMarshaler); ok {
-		text, err := m.MarshalText()
-		if err != nil {
-			fail(err)
-		}
-		in = reflect.ValueOf(string(text))
+	if e.level == 0 && !isMapping(in) {
+		failf("cannot marshal %s as an INI document", in.Type())
 	}
 	switch in.Kind() {
-	case reflect.Interface:
-		if in.IsNil() {
-			e.nilv()
-		} else {
-			e.marshal(in.Elem())
-		}
 	case reflect.Map:
 		e.mapv(in)
-	case reflect.Ptr:
-		if in.IsNil() {
-			e.nilv()
+	case reflect.Struct:
+		e.structv(in)
+	case reflect.Slice:
+		if in.Type() == mapSliceType {
+			e.mapv(in)
 		} else {
-			e.marshal(in.Elem())
+			panic("cannot marshal type: " + in.Type().String())
 		}
 	case reflect.String:
 		e.stringv(in)
 	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
 		if in.Type() == durationType {
-			e.stringv(reflect.ValueOf(iface.(time.Duration).String()))
+			e.stringv(reflect.ValueOf(in.Interface().(time.Duration).String()))
 		} else {
 			e.intv(in)
 		}
@@ -110,7 +175,339 @@ func (e *encoder) marshal(in reflect.Value) {
 	}
 }
 
+// isMapping returns whether the prepared value in is encoded as a
+// collection of keys rather than as a scalar.
+func isMapping(in reflect.Value) bool {
+	if !in.IsValid() {
+		return false
+	}
+	return in.Kind() == reflect.Map || in.Kind() == reflect.Struct || in.Type() == mapSliceType
+}
+
+// mapv encodes a map or a MapSlice. Map keys are sorted so that the
+// output is deterministic, while a MapSlice keeps its order.
 func (e *encoder) mapv(in reflect.Value) {
+	keys, values := mapItems(in)
+	e.itemsv(keys, values, nil)
+}
+
+// structv encodes the exported fields of a struct in declaration order,
+// keyed by the names computed by getStructInfo.
+func (e *encoder) structv(in reflect.Value) {
+	keys, values, flows := structItems(in)
+	e.itemsv(keys, values, flows)
+}
+
+// itemsv encodes the entries of a map or struct.
+//
+// At the top of the document the scalar entries make up the default
+// section and the map and struct entries become sections of their own,
+// in that order, unless flows marks them to be flattened into the default
+// section. Inside a section every entry is a key, and nested maps are
+// flattened into dotted keys. The struct fields of a struct become
+// sections named after a dotted path, as in [database.primary], after
+// the other keys, unless flows marks them as well.
+func (e *encoder) itemsv(keys, values []reflect.Value, flows []bool) {
+	switch e.level {
+	case 0:
+		e.level++
+		var sections []int
+		for i := range keys {
+			values[i] = e.prepare(values[i])
+			if isMapping(values[i]) && (flows == nil || !flows[i]) {
+				sections = append(sections, i)
+			} else {
+				e.itemv(keys[i], values[i])
+			}
+		}
+		for _, i := range sections {
+			e.sectionv(keys[i], values[i])
+		}
+		e.level--
+	case 1:
+		var sections []int
+		for i := range keys {
+			if flows != nil && !flows[i] {
+				values[i] = e.prepare(values[i])
+				if values[i].IsValid() && values[i].Kind() == reflect.Struct {
+					sections = append(sections, i)
+					continue
+				}
+			}
+			e.itemv(keys[i], values[i])
+		}
+		for _, i := range sections {
+			e.sectionv(keys[i], values[i])
+		}
+	default:
+		failf("cannot marshal a map or struct as a key")
+	}
+}
+
+// mapItems returns the keys and values of a map or a MapSlice.
+func mapItems(in reflect.Value) (keys, values []reflect.Value) {
+	if in.Kind() == reflect.Map {
+		keys = keyList(in.MapKeys())
+		sort.Sort(keyList(keys))
+		values = make([]reflect.Value, len(keys))
+		for i, k := range keys {
+			values[i] = in.MapIndex(k)
+		}
+		return keys, values
+	}
+	for _, item := range in.Interface().(MapSlice) {
+		keys = append(keys, reflect.ValueOf(item.Key))
+		values = append(values, reflect.ValueOf(item.Value))
+	}
+	return keys, values
+}
+
+// structItems returns the keys and values of the exported fields of a
+// struct, along with whether each field was tagged with the flow option.
+// Fields tagged with omitempty are left out when they hold a zero value.
+func structItems(in reflect.Value) (keys, values []reflect.Value, flows []bool) {
+	sinfo, err := getStructInfo(in.Type())
+	if err != nil {
+		panic(err)
+	}
+	for _, info := range sinfo.FieldsList {
+		var value reflect.Value
+		if info.Inline == nil {
+			value = in.Field(info.Num)
+		} else {
+			value = in.FieldByIndex(info.Inline)
+		}
+		if !value.CanInterface() || info.OmitEmpty && isZero(value) {
+			continue
+		}
+		if value.Kind() == reflect.Ptr && value.IsNil() && value.Type().Elem().Kind() == reflect.Struct {
+			// An unset section is left out rather than written as null.
+			continue
+		}
+		keys = append(keys, reflect.ValueOf(info.Key))
+		values = append(values, value)
+		flows = append(flows, info.Flow)
+	}
+	return keys, values, flows
+}
+
+func (e *encoder) sectionv(name, in reflect.Value) {
+	name = e.prepare(name)
+	if !name.IsValid() {
+		failf("cannot marshal a null section name")
+	}
+	section := e.section
+	if section == "" {
+		e.section = fmt.Sprint(name.Interface())
+	} else {
+		e.section += "." + fmt.Sprint(name.Interface())
+	}
+	e.must(ini_section_entry_event_initialize(&e.event, []byte(e.section)))
+	e.emit()
+	e.marshal(in)
+	e.section = section
+}
+
+// itemv encodes a single key of a section. A map or struct value is
+// flattened into one dotted key per leaf, so that
+//
+//	map[string]interface{}{"a": map[int]string{1: "x", 2: "y"}}
+//
+// is written back the way the scanner splits it apart:
+//
+//	a.1 = x
+//	a.2 = y
+func (e *encoder) itemv(key, value reflect.Value) {
+	value = e.prepare(value)
+	if isArray(value) {
+		e.arrayv(key, value)
+		return
+	}
+	if isMapping(value) {
+		if e.maxDepth > 0 && len(e.path)+1 >= e.maxDepth {
+			failf("cannot marshal %s: dotted key exceeds the maximum depth of %d", e.pathString(key), e.maxDepth)
+		}
+		var keys, values []reflect.Value
+		if value.Kind() == reflect.Struct {
+			keys, values, _ = structItems(value)
+		} else {
+			keys, values = mapItems(value)
+		}
+		e.path = append(e.path, key)
+		for i := range keys {
+			e.itemv(keys[i], values[i])
+		}
+		e.path = e.path[:len(e.path)-1]
+		return
+	}
+	e.level++
+	e.keyv(key)
+	e.marshal(value)
+	e.level--
+}
+
+// keyv encodes key, as the last segment of a dotted key when the maps of
+// e.path are being flattened.
+func (e *encoder) keyv(key reflect.Value) {
+	if len(e.path) == 0 {
+		e.marshal(key)
+		return
+	}
+	for _, k := range e.path {
+		e.segmentv(k)
+		e.must(ini_mapping_event_initialize(&e.event))
+		e.emit()
+	}
+	e.segmentv(key)
+}
+
+// segmentv encodes a segment of a dotted key. The scanner only splits
+// plain keys apart, so the segments are written plain whatever they would
+// be read back as, and the decoder turns them back into the keys of the
+// target map.
+func (e *encoder) segmentv(in reflect.Value) {
+	in = e.prepare(in)
+	if !in.IsValid() {
+		e.nilv()
+		return
+	}
+	e.emitNode(fmt.Sprint(in.Interface()), ini_PLAIN_SCALAR_STYLE)
+}
+
+// isArray returns whether the prepared value in is a slice or an array
+// written as several values of a key.
+func isArray(in reflect.Value) bool {
+	if !in.IsValid() {
+		return false
+	}
+	return in.Kind() == reflect.Array || in.Kind() == reflect.Slice && in.Type() != mapSliceType
+}
+
+// arrayv encodes the items of the slice or array in as the values of key,
+// in the array style of the encoder, the way the decoder reads them back
+// into a slice.
+//
+// With delimited values the items are joined into a single value written
+// plain if possible, so that each item is resolved on its own when read
+// back. A '\' or a delimiter inside an item is escaped with a '\'.
+func (e *encoder) arrayv(key, in reflect.Value) {
+	items := make([]reflect.Value, in.Len())
+	for i := range items {
+		items[i] = e.prepare(in.Index(i))
+		if isArray(items[i]) || isMapping(items[i]) {
+			failf("cannot marshal %s: arrays may only hold scalars", e.pathString(key))
+		}
+	}
+	switch e.arrayStyle {
+	case DelimitedValues:
+		delimiter := e.delimiter
+		if delimiter == "" {
+			delimiter = ","
+		}
+		escaper := strings.NewReplacer(`\`, `\\`, delimiter, `\`+delimiter)
+		values := make([]string, len(items))
+		for i, item := range items {
+			if item.IsValid() {
+				values[i] = escaper.Replace(fmt.Sprint(item.Interface()))
+			}
+		}
+		e.level++
+		e.keyv(key)
+		e.emitNode(strings.Join(values, delimiter+" "), ini_PLAIN_SCALAR_STYLE)
+		e.level--
+		return
+	case BracketKeys:
+		key = reflect.ValueOf(fmt.Sprint(key.Interface()) + "[]")
+	}
+	for _, item := range items {
+		e.itemv(key, item)
+	}
+}
+
+// pathString returns the dotted key leading to key, for error messages.
+func (e *encoder) pathString(key reflect.Value) string {
+	var segments []string
+	for _, k := range append(e.path, key) {
+		segments = append(segments, fmt.Sprint(k.Interface()))
+	}
+	return strings.Join(segments, ".")
+}
+
+// fileOf returns the *File held by in, if any.
+func fileOf(in reflect.Value) (*File, bool) {
+	if !in.IsValid() || !in.CanInterface() {
+		return nil, false
+	}
+	f, ok := in.Interface().(*File)
+	return f, ok && f != nil
+}
+
+// documentNode encodes a whole document node along with its comments,
+// finishing the document.
+func (e *encoder) documentNode(doc *node) {
+	e.level++
+	for i := 0; i < len(doc.children); i += 2 {
+		name, body := doc.children[i], doc.children[i+1]
+		// The emitter leaves out the header of a leading default section.
+		e.must(ini_section_entry_event_initialize(&e.event, []byte(name.value)))
+		e.event.head_comment = []byte(name.headComment)
+		e.event.line_comment = []byte(body.lineComment)
+		e.emit()
+		for _, parent := range explicitParents(body) {
+			e.must(ini_section_inherit_event_initialize(&e.event, []byte(parent)))
+			e.emit()
+		}
+		e.entriesNode(nil, body)
+	}
+	e.level--
+	e.must(ini_document_end_event_initialize(&e.event))
+	e.event.foot_comment = []byte(doc.footComment)
+	e.emit()
+}
+
+// entriesNode encodes the keys of a section or mapping node, writing the
+// keys of nested mappings as dotted keys below path. The segments of a
+// dotted key are written plain, as segmentv writes them.
+func (e *encoder) entriesNode(path []*node, n *node) {
+	for i := 0; i < len(n.children); i += 2 {
+		key, value := n.children[i], n.children[i+1]
+		if value.kind == mappingNode {
+			e.entriesNode(append(path, key), value)
+			continue
+		}
+		values := []*node{value}
+		if value.kind == sequenceNode {
+			values = value.children
+			if !value.repeated {
+				key = &node{kind: scalarNode, tag: key.tag, value: key.value + "[]", headComment: key.headComment}
+			}
+		}
+		e.headComment = []byte(key.headComment)
+		for _, value := range values {
+			if len(path) == 0 {
+				e.scalarNode(key)
+			} else {
+				for _, k := range path {
+					e.emitNode(k.value, ini_PLAIN_SCALAR_STYLE)
+					e.must(ini_mapping_event_initialize(&e.event))
+					e.emit()
+				}
+				e.emitNode(key.value, ini_PLAIN_SCALAR_STYLE)
+			}
+			e.lineComment = []byte(value.lineComment)
+			e.scalarNode(value)
+		}
+	}
+}
+
+// scalarNode encodes a scalar node, quoting it only if it was quoted when
+// parsed and would otherwise be read back as something else.
+func (e *encoder) scalarNode(n *node) {
+	if n.tag == ini_STR_TAG {
+		e.stringv(reflect.ValueOf(n.value))
+	} else {
+		e.emitNode(n.value, ini_PLAIN_SCALAR_STYLE)
+	}
 }
 
 // isBase60 returns whether s is in base 60 notation as defined in YAML 1.1.
@@ -138,10 +535,10 @@ var base60float = regexp.MustCompile(`^[-+]?[0-9][0-9_]*(?::[0-5]?[0-9])+(?:\.[0
 func (e *encoder) stringv(in reflect.Value) {
 	var style ini_scalar_style_t
 	s := in.String()
-	if isBase60Float(s) {
+	rtag, _ := resolve("", s)
+	if rtag != ini_STR_TAG || isBase60Float(s) {
+		// Quote strings that would otherwise be read back as another type.
 		style = ini_DOUBLE_QUOTED_SCALAR_STYLE
-	} else if strings.Contains(s, "\n") {
-		style = ini_PLAIN_SCALAR_STYLE
 	} else {
 		style = ini_PLAIN_SCALAR_STYLE
 	}
@@ -169,8 +566,11 @@ func (e *encoder) uintv(in reflect.Value) {
 }
 
 func (e *encoder) floatv(in reflect.Value) {
-	// FIXME: Handle 64 bits here.
-	s := strconv.FormatFloat(float64(in.Float()), 'g', -1, 32)
+	precision := 64
+	if in.Kind() == reflect.Float32 {
+		precision = 32
+	}
+	s := strconv.FormatFloat(in.Float(), 'g', -1, precision)
 	switch s {
 	case "+Inf":
 		s = ".inf"
@@ -183,10 +583,16 @@ func (e *encoder) floatv(in reflect.Value) {
 }
 
 func (e *encoder) nilv() {
+	if e.level == 0 {
+		// A nil document is an empty document.
+		return
+	}
 	e.emitNode("null", ini_PLAIN_SCALAR_STYLE)
 }
 
 func (e *encoder) emitNode(value string, style ini_scalar_style_t) {
 	e.must(ini_scalar_event_initialize(&e.event, []byte(value), style))
+	e.event.head_comment, e.headComment = e.headComment, nil
+	e.event.line_comment, e.lineComment = e.lineComment, nil
 	e.emit()
 }
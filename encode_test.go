@@ -1,110 +1,583 @@
 package ini_test
 
-/*
+import (
+	"bytes"
+	"errors"
+	. "gopkg.in/check.v1"
+	"math"
+	"time"
+
+	"go-ini"
+)
+
 var marshalTests = []struct {
-value interface{}
-data  string
+	value interface{}
+	data  string
 }{
-{
-    nil,
-    "null\n",
-}, {
-    map[string]string{"v": "hi"},
-    "v: hi\n",
-
-        }, {
-            map[string]interface{}{"v": "hi"},
-            "v: hi\n",
-        }, {
-            map[string]string{"v": "true"},
-            "v: \"true\"\n",
-        }, {
-            map[string]string{"v": "false"},
-            "v: \"false\"\n",
-        }, {
-            map[string]interface{}{"v": true},
-            "v: true\n",
-        }, {
-            map[string]interface{}{"v": false},
-            "v: false\n",
-        }, {
-            map[string]interface{}{"v": 10},
-            "v: 10\n",
-        }, {
-            map[string]interface{}{"v": -10},
-            "v: -10\n",
-        }, {
-            map[string]uint{"v": 42},
-            "v: 42\n",
-        }, {
-            map[string]interface{}{"v": int64(4294967296)},
-            "v: 4294967296\n",
-        }, {
-            map[string]int64{"v": int64(4294967296)},
-            "v: 4294967296\n",
-        }, {
-            map[string]uint64{"v": 4294967296},
-            "v: 4294967296\n",
-        }, {
-            map[string]interface{}{"v": "10"},
-            "v: \"10\"\n",
-        }, {
-            map[string]interface{}{"v": 0.1},
-            "v: 0.1\n",
-        }, {
-            map[string]interface{}{"v": float64(0.1)},
-            "v: 0.1\n",
-        }, {
-            map[string]interface{}{"v": -0.1},
-            "v: -0.1\n",
-        }, {
-            map[string]interface{}{"v": math.Inf(+1)},
-            "v: .inf\n",
-        }, {
-            map[string]interface{}{"v": math.Inf(-1)},
-            "v: -.inf\n",
-        }, {
-            map[string]interface{}{"v": math.NaN()},
-            "v: .nan\n",
-        }, {
-            map[string]interface{}{"v": nil},
-            "v: null\n",
-        }, {
-            map[string]interface{}{"v": ""},
-            "v: \"\"\n",
-        }, {
-            map[string]interface{}{"v": map[string]string{"0": "A", "1": "B"}},
-            "v.0:A\nv.1:B\n",
-        }, {
-            map[string]interface{}{"v": map[string]interface{}{"0": "A", "1": map[string]string{"1": "B"}}},
-            "v.0:A\nv.1.1:B\n",
-        }, {
-            map[string]interface{}{"v": map[string]interface{}{"0": "A", "1": map[string]string{"1": "B", "2": "C"}}},
-            "v.0:A\nv.1.1:B\nv.1.2=C\n",
-        }, {
-            map[string]interface{}{"a": "="},
-            "a='='",
-        }, {
-            map[string]interface{}{"a": "[A]"},
-            "a='[A]'",
-        }, {
-            map[string]interface{}{"a": "[A:B]"},
-            "a='[A:B]'",
+	{
+		nil,
+		"",
+	}, {
+		map[string]string{"v": "hi"},
+		"v = hi\n",
+	}, {
+		map[string]interface{}{"v": "hi"},
+		"v = hi\n",
+	}, {
+		map[string]string{"v": "true"},
+		"v = \"true\"\n",
+	}, {
+		map[string]string{"v": "false"},
+		"v = \"false\"\n",
+	}, {
+		map[string]interface{}{"v": true},
+		"v = true\n",
+	}, {
+		map[string]interface{}{"v": false},
+		"v = false\n",
+	}, {
+		map[string]interface{}{"v": 10},
+		"v = 10\n",
+	}, {
+		map[string]interface{}{"v": -10},
+		"v = -10\n",
+	}, {
+		map[string]uint{"v": 42},
+		"v = 42\n",
+	}, {
+		map[string]interface{}{"v": int64(4294967296)},
+		"v = 4294967296\n",
+	}, {
+		map[string]int64{"v": int64(4294967296)},
+		"v = 4294967296\n",
+	}, {
+		map[string]uint64{"v": 4294967296},
+		"v = 4294967296\n",
+	}, {
+		map[string]interface{}{"v": "10"},
+		"v = \"10\"\n",
+	}, {
+		map[string]interface{}{"v": 0.1},
+		"v = 0.1\n",
+	}, {
+		map[string]interface{}{"v": float64(0.1)},
+		"v = 0.1\n",
+	}, {
+		map[string]interface{}{"v": -0.1},
+		"v = -0.1\n",
+	}, {
+		map[string]interface{}{"v": 685230.15},
+		"v = 685230.15\n",
+	}, {
+		map[string]interface{}{"v": math.Inf(+1)},
+		"v = .inf\n",
+	}, {
+		map[string]interface{}{"v": math.Inf(-1)},
+		"v = -.inf\n",
+	}, {
+		map[string]interface{}{"v": math.NaN()},
+		"v = .nan\n",
+	}, {
+		map[string]interface{}{"v": nil},
+		"v = null\n",
+	}, {
+		map[string]interface{}{"v": ""},
+		"v = \"\"\n",
+	}, {
+		map[string]interface{}{"v": 3 * time.Second},
+		"v = 3s\n",
+	},
+
+	// Quoting.
+	{
+		map[string]interface{}{"a": "="},
+		"a = \"=\"\n",
+	}, {
+		map[string]interface{}{"k": "C:\\dir\\"},
+		"k = \"C:\\\\dir\\\\\"\n",
+	}, {
+		map[string]interface{}{"a": "[A]"},
+		"a = \"[A]\"\n",
+	}, {
+		map[string]interface{}{"a": "[A:B]"},
+		"a = \"[A:B]\"\n",
+	}, {
+		map[string]interface{}{"a": " b "},
+		"a = \" b \"\n",
+	}, {
+		map[string]interface{}{"a": "say \"hi\"\nbye"},
+		"a = \"say \\\"hi\\\"\\nbye\"\n",
+	}, {
+		map[string]interface{}{"a": "it's"},
+		"a = it's\n",
+	}, {
+		map[string]interface{}{"y": 1},
+		"\"y\" = 1\n",
+	}, {
+		map[interface{}]interface{}{1: "a"},
+		"1 = a\n",
+	},
+
+	// Sorting.
+	{
+		map[string]int{"b": 2, "a": 1, "a10": 4, "a2": 3},
+		"a = 1\na2 = 3\na10 = 4\nb = 2\n",
+	}, {
+		ini.MapSlice{{Key: "b", Value: 2}, {Key: "a", Value: 1}},
+		"b = 2\na = 1\n",
+	},
+
+	// Sections.
+	{
+		map[string]interface{}{"section": map[string]string{"hello": "world"}},
+		"[section]\nhello = world\n",
+	}, {
+		map[string]interface{}{
+			"s2":    map[string]int{"b": 2},
+			"hello": "world",
+			"s1":    map[string]int{"a": 1},
+		},
+		"hello = world\n\n[s1]\na = 1\n\n[s2]\nb = 2\n",
+	}, {
+		ini.MapSlice{
+			{Key: "s2", Value: ini.MapSlice{{Key: "b", Value: 2}}},
+			{Key: "hello", Value: "world"},
+			{Key: "s1", Value: map[string]int{"a": 1}},
+		},
+		"hello = world\n\n[s2]\nb = 2\n\n[s1]\na = 1\n",
+	}, {
+		map[string]interface{}{"empty": map[string]string{}},
+		"[empty]\n",
+	},
+
+	// Structs.
+	{
+		&struct {
+			Hello string
+		}{"world"},
+		"hello = world\n",
+	}, {
+		&struct {
+			B int
+			A int `ini:"renamed"`
+			C int `ini:"-"`
+			d int
+		}{1, 2, 3, 4},
+		"b = 1\nrenamed = 2\n",
+	}, {
+		struct {
+			Server struct {
+				Port    int
+				Timeout time.Duration
+			}
+			Name     string
+			Database *struct{ Host string }
+			Cache    *struct{ Size int }
+		}{
+			Server: struct {
+				Port    int
+				Timeout time.Duration
+			}{8080, 5 * time.Second},
+			Name:     "app",
+			Database: &struct{ Host string }{"localhost"},
+		},
+		"name = app\n\n[server]\nport = 8080\ntimeout = 5s\n\n[database]\nhost = localhost\n",
+	}, {
+		map[string]interface{}{"section": struct{ A, B string }{"x", "z"}},
+		"[section]\na = x\nb = z\n",
+	},
+
+	// Dotted keys.
+	{
+		map[string]interface{}{"s": map[string]interface{}{"v": map[string]string{"0": "A", "1": "B"}}},
+		"[s]\nv.0 = A\nv.1 = B\n",
+	}, {
+		map[string]interface{}{"s": map[string]interface{}{"v": map[int]interface{}{0: "A", 1: map[int]string{1: "B", 2: "C"}}}},
+		"[s]\nv.0 = A\nv.1.1 = B\nv.1.2 = C\n",
+	}, {
+		map[string]interface{}{"s": map[string]interface{}{"a": 1, "b": map[string]string{}}},
+		"[s]\na = 1\n",
+	}, {
+		struct {
+			S struct{ K struct{ C, D int } }
+		}{},
+		"[s]\n\n[s.k]\nc = 0\nd = 0\n",
+	}, {
+		struct {
+			Database struct {
+				Name    string
+				Primary struct{ Host string }
+				Replica *struct{ Host string }
+				Options struct{ Pool int } `ini:",flow"`
+				Limits  map[string]int
+			}
+		}{},
+		"[database]\nname = \"\"\noptions.pool = 0\n\n[database.primary]\nhost = \"\"\n",
+	},
+
+	// Struct tag options.
+	{
+		&struct {
+			A int    `ini:"a,omitempty"`
+			B string `ini:"b,omitempty"`
+			C *int   `ini:"c,omitempty"`
+			D int
+		}{},
+		"d = 0\n",
+	}, {
+		&struct {
+			A int `ini:"a,omitempty"`
+			B struct {
+				C int `ini:",omitempty"`
+				D int `ini:",omitempty"`
+			} `ini:",omitempty"`
+		}{1, struct {
+			C int `ini:",omitempty"`
+			D int `ini:",omitempty"`
+		}{0, 2}},
+		"a = 1\n\n[b]\nd = 2\n",
+	}, {
+		&struct {
+			A     int
+			Inner struct{ B, C int } `ini:",inline"`
+		}{1, struct{ B, C int }{2, 3}},
+		"a = 1\nb = 2\nc = 3\n",
+	}, {
+		&struct {
+			Section struct {
+				Inner struct {
+					Server struct{ Port int }
+				} `ini:",inline"`
+			}
+		}{},
+		"[section]\n\n[section.server]\nport = 0\n",
+	}, {
+		&struct {
+			Server struct{ Port int } `ini:",flow"`
+			Name   string
+			Client struct{ Port int }
+		}{Name: "app"},
+		"server.port = 0\nname = app\n\n[client]\nport = 0\n",
+	}, {
+		map[string]interface{}{"v": []string{"a", "true"}, "s": map[string]interface{}{"w": []int{1, 2}, "m": map[string][]int{"k": {3}}}},
+		"v = a\nv = \"true\"\n\n[s]\nm.k = 3\nw = 1\nw = 2\n",
 	},
 }
 
-func TestMarshal(t *testing.T) {
-	defer os.Setenv("TZ", os.Getenv("TZ"))
-	os.Setenv("TZ", "UTC")
+func (s *S) TestMarshal(c *C) {
 	for _, item := range marshalTests {
 		data, err := ini.Marshal(item.value)
-		if err != nil {
-			t.Error("TestUnmarshal Failed")
-		}
-		if string(data) != item.data {
-			t.Error("TestUnmarshal Failed")
-		}
+		c.Assert(err, IsNil)
+		c.Assert(string(data), Equals, item.data)
+	}
+}
+
+var marshalErrorTests = []struct {
+	value interface{}
+	error string
+}{
+	{
+		"hello",
+		"ini: cannot marshal string as an INI document",
+	}, {
+		map[string]interface{}{"a.b": 1},
+		"ini: key contains '.' which would be scanned as a map key",
+	}, {
+		map[string]interface{}{"a b": map[string]int{"c": 1}},
+		"ini: section name contains characters that cannot be scanned back",
+	}, {
+		map[string]interface{}{"s": map[interface{}]int{struct{ A int }{1}: 1}},
+		"ini: cannot marshal a map or struct as a key",
+	}, {
+		map[string]interface{}{"s": map[string]interface{}{"a": [][]int{{1}}}},
+		"ini: cannot marshal a: arrays may only hold scalars",
+	}, {
+		map[string]interface{}{"s": map[string]interface{}{"a=b": map[string]int{"c": 1}}},
+		"ini: dotted key segment cannot be written plain",
+	},
+}
+
+func (s *S) TestMarshalErrors(c *C) {
+	for _, item := range marshalErrorTests {
+		_, err := ini.Marshal(item.value)
+		c.Assert(err, ErrorMatches, item.error)
+	}
+}
+
+func (s *S) TestMarshalBadTagOptions(c *C) {
+	c.Assert(func() {
+		ini.Marshal(&struct {
+			A int `ini:"a,unknown"`
+		}{})
+	}, PanicMatches, `Unsupported flag "unknown" in tag "a,unknown" of type .*`)
+	c.Assert(func() {
+		ini.Marshal(&struct {
+			A int `ini:",inline"`
+		}{})
+	}, PanicMatches, "Option ,inline needs a struct value field")
+	c.Assert(func() {
+		ini.Marshal(&struct {
+			A     int
+			Inner struct{ A int } `ini:",inline"`
+		}{})
+	}, PanicMatches, "Duplicated key 'a' in struct .*")
+}
+
+func (s *S) TestEncoder(c *C) {
+	for _, item := range marshalTests {
+		var buf bytes.Buffer
+		err := ini.NewEncoder(&buf).Encode(item.value)
+		c.Assert(err, IsNil)
+		c.Assert(buf.String(), Equals, item.data)
+	}
+}
+
+func (s *S) TestEncoderErrors(c *C) {
+	for _, item := range marshalErrorTests {
+		var buf bytes.Buffer
+		err := ini.NewEncoder(&buf).Encode(item.value)
+		c.Assert(err, ErrorMatches, item.error)
+	}
+}
+
+func (s *S) TestEncoderLineBreak(c *C) {
+	value := ini.MapSlice{{Key: "a", Value: 1}, {Key: "s", Value: map[string]int{"b": 2}}}
+	for _, item := range []struct {
+		lb   ini.LineBreak
+		data string
+	}{
+		{ini.LineBreakLN, "a = 1\n\n[s]\nb = 2\n"},
+		{ini.LineBreakCR, "a = 1\r\r[s]\rb = 2\r"},
+		{ini.LineBreakCRLN, "a = 1\r\n\r\n[s]\r\nb = 2\r\n"},
+	} {
+		var buf bytes.Buffer
+		enc := ini.NewEncoder(&buf)
+		enc.SetLineBreak(item.lb)
+		c.Assert(enc.Encode(value), IsNil)
+		c.Assert(buf.String(), Equals, item.data)
+	}
+
+	// CRLF output must read back the same.
+	var buf bytes.Buffer
+	enc := ini.NewEncoder(&buf)
+	enc.SetLineBreak(ini.LineBreakCRLN)
+	c.Assert(enc.Encode(map[string]interface{}{"a": "x y", "s": map[string]string{"b": "two\nlines"}}), IsNil)
+	var again map[string]interface{}
+	c.Assert(ini.Unmarshal(buf.Bytes(), &again), IsNil)
+	c.Assert(again, DeepEquals, map[string]interface{}{
+		"a": "x y",
+		"s": map[interface{}]interface{}{"a": "x y", "b": "two\nlines"},
+	})
+}
+
+func (s *S) TestEncoderUnicode(c *C) {
+	value := map[string]string{"a": "h\u00e9llo \u4e16\u754c"}
+	var buf bytes.Buffer
+	c.Assert(ini.NewEncoder(&buf).Encode(value), IsNil)
+	c.Assert(buf.String(), Equals, "a = h\u00e9llo \u4e16\u754c\n")
+
+	buf.Reset()
+	enc := ini.NewEncoder(&buf)
+	enc.SetUnicode(false)
+	c.Assert(enc.Encode(value), IsNil)
+	c.Assert(buf.String(), Equals, "a = \"h\\xE9llo \\u4E16\\u754C\"\n")
+	var again map[string]string
+	c.Assert(ini.Unmarshal(buf.Bytes(), &again), IsNil)
+	c.Assert(again, DeepEquals, value)
+}
+
+func (s *S) TestEncoderNameGrammar(c *C) {
+	value := map[string]map[string]int{"Network Settings": {"port": 80}}
+	var buf bytes.Buffer
+	err := ini.NewEncoder(&buf).Encode(value)
+	c.Assert(err, ErrorMatches, "ini: section name contains characters that cannot be scanned back")
+
+	buf.Reset()
+	enc := ini.NewEncoder(&buf)
+	enc.SetNameGrammar(ini.PermissiveNames)
+	c.Assert(enc.Encode(value), IsNil)
+	c.Assert(buf.String(), Equals, "[Network Settings]\nport = 80\n")
+}
+
+func (s *S) TestEncoderArrayStyle(c *C) {
+	value := map[string][]interface{}{"hosts": {"a", "b"}, "ports": {80, 443}}
+	for _, item := range []struct {
+		style ini.ArrayStyle
+		data  string
+	}{
+		{ini.RepeatedKeys, "hosts = a\nhosts = b\nports = 80\nports = 443\n"},
+		{ini.BracketKeys, "hosts[] = a\nhosts[] = b\nports[] = 80\nports[] = 443\n"},
+		{ini.DelimitedValues, "hosts = a, b\nports = 80, 443\n"},
+	} {
+		var buf bytes.Buffer
+		enc := ini.NewEncoder(&buf)
+		enc.SetArrayStyle(item.style)
+		c.Assert(enc.Encode(value), IsNil)
+		c.Assert(buf.String(), Equals, item.data)
+
+		dec := ini.NewDecoder(&buf)
+		dec.SetDelimiter(",")
+		var again map[string][]interface{}
+		c.Assert(dec.Decode(&again), IsNil)
+		c.Assert(again, DeepEquals, value)
+	}
+}
+
+func (s *S) TestEncoderDelimitedValues(c *C) {
+	type T struct {
+		Names []string
+		Ports []int
+		Empty []int
+		Flags []bool
+	}
+	value := T{
+		Names: []string{"a,b", `C:\`, "x"},
+		Ports: []int{1},
+		Empty: []int{},
+		Flags: []bool{true, false},
+	}
+	var buf bytes.Buffer
+	enc := ini.NewEncoder(&buf)
+	enc.SetArrayStyle(ini.DelimitedValues)
+	c.Assert(enc.Encode(value), IsNil)
+	c.Assert(buf.String(), Equals, "names = a\\,b, C:\\\\, x\nports = 1\nempty = \"\"\nflags = true, false\n")
+
+	dec := ini.NewDecoder(&buf)
+	dec.SetDelimiter(",")
+	var again T
+	c.Assert(dec.Decode(&again), IsNil)
+	c.Assert(again, DeepEquals, value)
+}
+
+func (s *S) TestEncoderMaxDepth(c *C) {
+	var buf bytes.Buffer
+	enc := ini.NewEncoder(&buf)
+	enc.SetMaxDepth(1)
+	err := enc.Encode(map[string]interface{}{"s": map[string]interface{}{"a": map[string]int{"b": 1}}})
+	c.Assert(err, ErrorMatches, "ini: cannot marshal a: dotted key exceeds the maximum depth of 1")
+}
+
+func (s *S) TestEncoderSingleDocument(c *C) {
+	var buf bytes.Buffer
+	enc := ini.NewEncoder(&buf)
+	c.Assert(enc.Encode(map[string]int{"a": 1}), IsNil)
+	err := enc.Encode(map[string]int{"b": 2})
+	c.Assert(err, ErrorMatches, "ini: an INI stream holds a single document; Encode may only be called once")
+	c.Assert(buf.String(), Equals, "a = 1\n")
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func (s *S) TestEncoderWriteError(c *C) {
+	err := ini.NewEncoder(failingWriter{}).Encode(map[string]int{"a": 1})
+	c.Assert(err, ErrorMatches, "ini: write error: disk full")
+}
+
+func (s *S) TestMarshalDepth(c *C) {
+	value := map[string]interface{}{
+		"s": map[string]interface{}{"a": map[string]interface{}{"b": map[string]int{"c": 1}}},
 	}
+	data, err := ini.MarshalDepth(value, 3)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "[s]\na.b.c = 1\n")
+	data, err = ini.MarshalDepth(value, 0)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "[s]\na.b.c = 1\n")
+	_, err = ini.MarshalDepth(value, 2)
+	c.Assert(err, ErrorMatches, "ini: cannot marshal a.b: dotted key exceeds the maximum depth of 2")
+	_, err = ini.MarshalDepth(value, 1)
+	c.Assert(err, ErrorMatches, "ini: cannot marshal a: dotted key exceeds the maximum depth of 1")
+}
+
+func (s *S) TestMarshalNestedRoundTrip(c *C) {
+	data := "[common]\nstring_1.1 = testing\nstring_3.1.1 = testing_1\nstring_3.1.2 = testing_2\n" +
+		"[dev:common]\nstring_1.1 = testing_dev\nstring_2.2 = testing_dev\nhello_2.2.1 = world\n"
+	var value interface{}
+	err := ini.Unmarshal([]byte(data), &value)
+	c.Assert(err, IsNil)
+	out, err := ini.Marshal(value)
+	c.Assert(err, IsNil)
+	var again interface{}
+	err = ini.Unmarshal(out, &again)
+	c.Assert(err, IsNil, Commentf("data: %q", out))
+	c.Assert(again, DeepEquals, value, Commentf("data: %q", out))
+}
+
+func (s *S) TestMarshalRoundTrip(c *C) {
+	data := "hello = world\nnumber = 8080\nquoted = \"on\"\n" +
+		"[section]\nhello = section\nfloat = 3.14\nescaped = \"a\\tb=c\"\n" +
+		"[other]\nswitch = off\n'y' = 'n'\n"
+	var value interface{}
+	err := ini.Unmarshal([]byte(data), &value)
+	c.Assert(err, IsNil)
+	out, err := ini.Marshal(value)
+	c.Assert(err, IsNil)
+	var again interface{}
+	err = ini.Unmarshal(out, &again)
+	c.Assert(err, IsNil, Commentf("data: %q", out))
+	c.Assert(again, DeepEquals, value, Commentf("data: %q", out))
+}
+
+func (s *S) TestMarshalReferenceRoundTrip(c *C) {
+	value := map[string]interface{}{"j": "${x}", "k": "$${x}", "l": "a ${x} b"}
+	out, err := ini.Marshal(value)
+	c.Assert(err, IsNil)
+	var again map[string]interface{}
+	err = ini.Unmarshal(out, &again)
+	c.Assert(err, IsNil, Commentf("data: %q", out))
+	c.Assert(again, DeepEquals, value, Commentf("data: %q", out))
+}
+
+func (s *S) TestMarshalDottedKeyRoundTrip(c *C) {
+	value := map[string]map[string]map[string]string{
+		"s": {"n": {"m": "2"}, "a": {"1": "x", "true": "on", "null": "z"}},
+	}
+	out, err := ini.Marshal(value)
+	c.Assert(err, IsNil)
+	c.Assert(string(out), Equals, "[s]\na.1 = x\na.null = z\na.true = \"on\"\nn.m = \"2\"\n")
+	var again map[string]map[string]map[string]string
+	err = ini.Unmarshal(out, &again)
+	c.Assert(err, IsNil, Commentf("data: %q", out))
+	c.Assert(again, DeepEquals, value, Commentf("data: %q", out))
+
+	ints := map[string]map[string]map[int]bool{"s": {"k": {1: true, 2: false}}}
+	out, err = ini.Marshal(ints)
+	c.Assert(err, IsNil)
+	var intsAgain map[string]map[string]map[int]bool
+	err = ini.Unmarshal(out, &intsAgain)
+	c.Assert(err, IsNil, Commentf("data: %q", out))
+	c.Assert(intsAgain, DeepEquals, ints, Commentf("data: %q", out))
+}
+
+func (s *S) TestMarshalStructRoundTrip(c *C) {
+	type Server struct {
+		Host    string
+		Port    int
+		Timeout time.Duration
+		Debug   bool
+	}
+	type Config struct {
+		Name   string
+		Ratio  float64
+		Server Server
+		Backup *Server
+	}
+	value := Config{
+		Name:   "app",
+		Ratio:  0.75,
+		Server: Server{"localhost", 8080, 3 * time.Second, true},
+		Backup: &Server{Host: "backup", Port: 8081},
+	}
+	data, err := ini.Marshal(&value)
+	c.Assert(err, IsNil)
+	var again Config
+	err = ini.Unmarshal(data, &again)
+	c.Assert(err, IsNil, Commentf("data: %q", data))
+	c.Assert(again, DeepEquals, value, Commentf("data: %q", data))
 }
 
 type marshalerType struct {
@@ -119,20 +592,19 @@ func (o marshalerType) MarshalINI() (interface{}, error) {
 	return o.value, nil
 }
 
-type marshalerValue struct {
-	Field marshalerType "_"
-}
-
-func TestMarshalerWholeDocument(t *testing.T) {
+func (s *S) TestMarshalerWholeDocument(c *C) {
 	obj := &marshalerType{}
 	obj.value = map[string]string{"hello": "world!"}
 	data, err := ini.Marshal(obj)
-	if err != nil {
-		t.Error("TestUnmarshal Failed")
-	}
-	if string(data) != "hello= world!\n" {
-		t.Error("TestUnmarshal Failed")
-	}
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "hello = world!\n")
+}
+
+func (s *S) TestMarshalerValue(c *C) {
+	obj := map[string]interface{}{"v": marshalerType{"hi"}}
+	data, err := ini.Marshal(obj)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, "v = hi\n")
 }
 
 type failingMarshaler struct{}
@@ -141,10 +613,7 @@ func (ft *failingMarshaler) MarshalINI() (interface{}, error) {
 	return nil, failingErr
 }
 
-func TestMarshalerError(t *testing.T) {
+func (s *S) TestMarshalerError(c *C) {
 	_, err := ini.Marshal(&failingMarshaler{})
-	if err != failingErr {
-		t.Error("TestUnmarshal Failed")
-	}
+	c.Assert(err, Equals, failingErr)
 }
-*/
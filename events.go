@@ -0,0 +1,164 @@
+package ini
+
+import (
+	"io"
+)
+
+// EventKind is the kind of an Event.
+type EventKind int
+
+// The kinds of the events of a document. A document is made of a
+// DocumentStartEvent, of its sections and of a DocumentEndEvent. A section
+// starts with a ScalarEvent holding its name, followed by a
+// SectionInheritEvent for each section it inherits and a
+// SectionEntryEvent, and ends with a SectionEndEvent. Its keys come in
+// between, each one as a ScalarEvent holding the key followed by
+// a ScalarEvent holding the value, or by a MappingEvent and the next key
+// of a dotted key. The keys before the first section header make up a
+// section named "default".
+const (
+	DocumentStartEvent EventKind = iota + 1
+	DocumentEndEvent
+	SectionInheritEvent
+	SectionEntryEvent
+	SectionEndEvent
+	MappingEvent
+	ScalarEvent
+)
+
+// The kinds of the events of the parser. It closes a section with a
+// SECTION-ENTRY event as well, which Next tells apart by its empty tag.
+var eventKinds = map[ini_event_type_t]EventKind{
+	ini_DOCUMENT_START_EVENT:  DocumentStartEvent,
+	ini_DOCUMENT_END_EVENT:    DocumentEndEvent,
+	ini_SECTION_INHERIT_EVENT: SectionInheritEvent,
+	ini_SECTION_ENTRY_EVENT:   SectionEntryEvent,
+	ini_MAPPING_EVENT:         MappingEvent,
+	ini_SCALAR_EVENT:          ScalarEvent,
+}
+
+func (k EventKind) String() string {
+	switch k {
+	case DocumentStartEvent:
+		return "DocumentStart"
+	case DocumentEndEvent:
+		return "DocumentEnd"
+	case SectionInheritEvent:
+		return "SectionInherit"
+	case SectionEntryEvent:
+		return "SectionEntry"
+	case SectionEndEvent:
+		return "SectionEnd"
+	case MappingEvent:
+		return "Mapping"
+	case ScalarEvent:
+		return "Scalar"
+	}
+	return "<unknown event>"
+}
+
+// ScalarStyle is the way a scalar is written.
+type ScalarStyle int
+
+const (
+	PlainStyle        = ScalarStyle(ini_PLAIN_SCALAR_STYLE)
+	SingleQuotedStyle = ScalarStyle(ini_SINGLE_QUOTED_SCALAR_STYLE)
+	DoubleQuotedStyle = ScalarStyle(ini_DOUBLE_QUOTED_SCALAR_STYLE)
+)
+
+// Mark is a position in a document.
+type Mark struct {
+	Line   int // Line, counting from 1.
+	Column int // Column, counting from 1.
+	Offset int // Offset, in characters from the start of the document.
+}
+
+// Event is an event of a document, as read by a Parser.
+type Event struct {
+	Kind EventKind
+
+	// Value is the section name, the inherited section, the key or the
+	// value of the event, without quotes or escape sequences.
+	Value string
+
+	// Tag is the tag set by the parser, as "str" for section names and
+	// "section" for the entry of a section, or is empty for the end of a
+	// section and for keys and values, whose tags are resolved from their
+	// value.
+	Tag string
+
+	// Style is the style of a key or value, or zero for other events.
+	Style ScalarStyle
+
+	// Start and End locate the event in the document.
+	Start, End Mark
+
+	// The comments attached to the event, as written: the comment lines
+	// above a section name or a key, the comment following a section
+	// header or a value on the same line, and the comment lines at the
+	// end of the document.
+	HeadComment string
+	LineComment string
+	FootComment string
+}
+
+// A Parser reads the events of an INI document one at a time, without
+// building the document in memory.
+type Parser struct {
+	parser *parser
+	err    error // The error Next returns from now on, if any.
+}
+
+// NewParser returns a new parser that reads from r.
+//
+// The parser introduces its own buffering and reads r incrementally, as
+// the events are asked for.
+func NewParser(r io.Reader) *Parser {
+	return &Parser{parser: newParserFromReader(r)}
+}
+
+// SetNameGrammar sets the characters allowed in section names, as
+// Decoder.SetNameGrammar does.
+func (p *Parser) SetNameGrammar(g NameGrammar) {
+	ini_parser_set_permissive_names(&p.parser.parser, g == PermissiveNames)
+}
+
+// Next returns the next event of the document. It returns io.EOF after
+// the DocumentEndEvent, and a SyntaxError if the document is malformed.
+// Once it has returned an error, Next returns that error again on every
+// later call.
+func (p *Parser) Next() (e Event, err error) {
+	if p.err != nil {
+		return Event{}, p.err
+	}
+	defer func() {
+		if err != nil {
+			p.err = err
+		}
+	}()
+	defer handleErr(&err)
+	p.parser.skip()
+	event := &p.parser.event
+	if event.typ == ini_DOCUMENT_END_EVENT {
+		p.err = io.EOF
+	}
+	kind := eventKinds[event.typ]
+	if event.typ == ini_SECTION_ENTRY_EVENT && len(event.tag) == 0 {
+		kind = SectionEndEvent
+	}
+	return Event{
+		Kind:        kind,
+		Value:       string(event.value),
+		Tag:         string(event.tag),
+		Style:       ScalarStyle(event.scalar_style()),
+		Start:       newMark(event.start_mark),
+		End:         newMark(event.end_mark),
+		HeadComment: string(event.head_comment),
+		LineComment: string(event.line_comment),
+		FootComment: string(event.foot_comment),
+	}, nil
+}
+
+func newMark(mark ini_mark_t) Mark {
+	return Mark{Line: mark.line + 1, Column: mark.column + 1, Offset: mark.index}
+}
@@ -0,0 +1,90 @@
+package ini_test
+
+import (
+	"errors"
+	. "gopkg.in/check.v1"
+	"io"
+	"strings"
+	"testing/iotest"
+
+	"go-ini"
+)
+
+func (s *S) TestParser(c *C) {
+	p := ini.NewParser(strings.NewReader("name = app\n# database\n[db : default]\nhost = \"x\" ; local\ntls.cert = ''\n"))
+	type event struct {
+		Kind  ini.EventKind
+		Value string
+		Style ini.ScalarStyle
+		Start ini.Mark
+	}
+	var events []event
+	for {
+		e, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, IsNil)
+		events = append(events, event{e.Kind, e.Value, e.Style, e.Start})
+		if e.Kind == ini.ScalarEvent && e.Value == "db" {
+			c.Assert(e.HeadComment, Equals, "# database")
+		}
+		if e.Kind == ini.ScalarEvent && e.Value == "host" {
+			c.Assert(e.End, Equals, ini.Mark{4, 5, 41})
+		}
+		if e.Kind == ini.ScalarEvent && e.Value == "x" {
+			c.Assert(e.LineComment, Equals, "; local")
+		}
+		if e.Kind == ini.SectionEntryEvent {
+			c.Assert(e.Tag, Equals, "section")
+		}
+		if e.Kind == ini.SectionEndEvent {
+			c.Assert(e.Tag, Equals, "")
+		}
+	}
+	c.Assert(events, DeepEquals, []event{
+		{ini.DocumentStartEvent, "", 0, ini.Mark{1, 1, 0}},
+		{ini.ScalarEvent, "default", 0, ini.Mark{1, 1, 0}},
+		{ini.SectionEntryEvent, "", 0, ini.Mark{1, 6, 5}},
+		{ini.ScalarEvent, "name", ini.PlainStyle, ini.Mark{1, 1, 0}},
+		{ini.ScalarEvent, "app", ini.PlainStyle, ini.Mark{1, 8, 7}},
+		{ini.SectionEndEvent, "", 0, ini.Mark{3, 1, 22}},
+		{ini.ScalarEvent, "db", 0, ini.Mark{3, 2, 23}},
+		{ini.SectionInheritEvent, "default", 0, ini.Mark{3, 7, 28}},
+		{ini.SectionEntryEvent, "", 0, ini.Mark{3, 14, 35}},
+		{ini.ScalarEvent, "host", ini.PlainStyle, ini.Mark{4, 1, 37}},
+		{ini.ScalarEvent, "x", ini.DoubleQuotedStyle, ini.Mark{4, 8, 44}},
+		{ini.ScalarEvent, "tls", ini.PlainStyle, ini.Mark{5, 1, 56}},
+		{ini.MappingEvent, "", 0, ini.Mark{5, 4, 59}},
+		{ini.ScalarEvent, "cert", ini.PlainStyle, ini.Mark{5, 5, 60}},
+		{ini.ScalarEvent, "", ini.SingleQuotedStyle, ini.Mark{5, 12, 67}},
+		{ini.SectionEndEvent, "", 0, ini.Mark{6, 1, 70}},
+		{ini.DocumentEndEvent, "", 0, ini.Mark{6, 1, 70}},
+	})
+
+	_, err := p.Next()
+	c.Assert(err, Equals, io.EOF)
+}
+
+func (s *S) TestParserSyntaxError(c *C) {
+	p := ini.NewParser(strings.NewReader("[s]\nk = \"abc"))
+	var err error
+	for err == nil {
+		_, err = p.Next()
+	}
+	c.Assert(err, ErrorMatches, "ini: line 2: found unexpected end of stream")
+	var syntaxErr *ini.SyntaxError
+	c.Assert(errors.As(err, &syntaxErr), Equals, true)
+	c.Assert(syntaxErr.Line, Equals, 2)
+
+	// The error is kept rather than followed by empty events.
+	e, err2 := p.Next()
+	c.Assert(err2, Equals, err)
+	c.Assert(e, DeepEquals, ini.Event{})
+}
+
+func (s *S) TestParserReadError(c *C) {
+	p := ini.NewParser(iotest.ErrReader(failingErr))
+	_, err := p.Next()
+	c.Assert(err, ErrorMatches, "ini: input error: failingErr")
+}
@@ -0,0 +1,593 @@
+package ini
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// File is an INI document loaded for reading and editing without
+// unmarshaling it into a Go value first.
+//
+// The keys of inherited sections are not copied into the sections
+// inheriting them, but they are found by Section.Key, so that writing the
+// document back keeps every key in the section that defines it.
+type File struct {
+	doc          *node
+	names        NameGrammar
+	duplicates   DuplicatePolicy
+	fold         bool
+	tolerant     bool
+	continuation bool
+}
+
+// Section is a section of a File.
+type Section struct {
+	file *File
+	name *node
+	body *node
+}
+
+// Key is a key of a Section. The keys of dotted key groups are named
+// after their full path, such as "server.port".
+type Key struct {
+	section *Section
+	name    string
+	key     *node
+	node    *node
+}
+
+// Load parses one or more INI documents and merges them into a single
+// File, as Append does. A source may be a []byte holding the document, a
+// string naming a file to read, an io.ReadCloser, which is closed once
+// read, or any other io.Reader. Loading no source at all gives an empty
+// document to build a new one from scratch.
+func Load(sources ...interface{}) (*File, error) {
+	f := &File{doc: &node{kind: documentNode}}
+	if err := f.Append(sources...); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Append parses each source in turn and merges it into the document.
+// A section defined more than once, in one source or across several, is
+// merged into its first definition, and later keys override earlier ones.
+// A section may inherit a section from any source, as long as it exists
+// once every source is merged.
+//
+// With SetTolerant, the sources with syntax errors are loaded as well,
+// and Append then returns a ParseError holding the errors of every source.
+func (f *File) Append(sources ...interface{}) (err error) {
+	var syntaxErr *ParseError
+	for _, source := range sources {
+		err := f.append(source)
+		if e, ok := err.(*ParseError); ok {
+			if syntaxErr == nil {
+				syntaxErr = &ParseError{}
+			}
+			syntaxErr.Errors = append(syntaxErr.Errors, e.Errors...)
+		} else if err != nil {
+			return err
+		}
+	}
+	defer handleErr(&err)
+	sectionOrder(f.doc, f.fold)
+	if syntaxErr != nil {
+		return syntaxErr
+	}
+	return nil
+}
+
+// SetNameGrammar sets the characters allowed in the section names of the
+// sources appended and of the document written from then on. Sources
+// with permissive names are loaded with
+//
+//	f, _ := ini.Load()
+//	f.SetNameGrammar(ini.PermissiveNames)
+//	err := f.Append(source)
+func (f *File) SetNameGrammar(g NameGrammar) {
+	f.names = g
+}
+
+// SetDuplicatePolicy sets what happens to the keys and sections defined
+// more than once in each of the sources appended from then on. Sources
+// still override the keys of the sources appended before them.
+func (f *File) SetDuplicatePolicy(policy DuplicatePolicy) {
+	f.duplicates = policy
+}
+
+// SetCaseInsensitive sets whether keys and section names match regardless
+// of case, both in the sources appended from then on and when looking
+// sections and keys up.
+func (f *File) SetCaseInsensitive(insensitive bool) {
+	f.fold = insensitive
+}
+
+// SetTolerant sets whether the sources appended from then on are loaded
+// past their syntax errors, the same way as with Decoder.SetTolerant.
+func (f *File) SetTolerant(tolerant bool) {
+	f.tolerant = tolerant
+}
+
+// SetContinuation sets whether the values of the sources appended from
+// then on go on over continuation lines, the same way as with
+// Decoder.SetContinuation.
+func (f *File) SetContinuation(continuation bool) {
+	f.continuation = continuation
+}
+
+func (f *File) append(source interface{}) (err error) {
+	defer handleErr(&err)
+	var p *parser
+	switch source := source.(type) {
+	case []byte:
+		p = newParser(source)
+	case string:
+		file, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		p = newParserFromReader(file)
+	case io.ReadCloser:
+		defer source.Close()
+		p = newParserFromReader(source)
+	case io.Reader:
+		p = newParserFromReader(source)
+	default:
+		return fmt.Errorf("ini: cannot load a source of type %T", source)
+	}
+	defer p.destroy()
+	ini_parser_set_permissive_names(&p.parser, f.names == PermissiveNames)
+	p.duplicates = f.duplicates
+	p.fold = f.fold
+	ini_parser_set_tolerant(&p.parser, f.tolerant)
+	ini_parser_set_continuation(&p.parser, f.continuation)
+	p.raw = true
+	p.init()
+	doc := p.parse()
+	if doc == nil {
+		return nil
+	}
+	for i := 0; i < len(doc.children); i += 2 {
+		name, body := doc.children[i], doc.children[i+1]
+		j := indexNode(f.doc, name.value, f.fold)
+		switch {
+		case j >= 0:
+			p.merge_node(f.doc.children[j+1], body, mergeOverwrite)
+			if parents := explicitParents(body); parents != nil {
+				f.doc.children[j+1].parents = parents
+			}
+		case sameName(name.value, DEFAULT_SECTION, f.fold):
+			f.doc.children = append([]*node{name, body}, f.doc.children...)
+		default:
+			f.doc.children = append(f.doc.children, name, body)
+		}
+	}
+	if doc.footComment != "" {
+		f.doc.footComment = doc.footComment
+	}
+	return p.syntaxErrors()
+}
+
+// Sections returns the sections of the document in order. The default
+// section, holding the keys that precede the first section header, is
+// included when the document has one.
+func (f *File) Sections() []*Section {
+	var sections []*Section
+	for i := 0; i < len(f.doc.children); i += 2 {
+		sections = append(sections, &Section{f, f.doc.children[i], f.doc.children[i+1]})
+	}
+	return sections
+}
+
+// Section returns the section with the given name, or nil if there is no
+// such section.
+func (f *File) Section(name string) *Section {
+	for i := len(f.doc.children) - 2; i >= 0; i -= 2 {
+		if sameName(f.doc.children[i].value, name, f.fold) {
+			return &Section{f, f.doc.children[i], f.doc.children[i+1]}
+		}
+	}
+	return nil
+}
+
+// NewSection adds an empty section to the end of the document, or to its
+// start for the default section.
+func (f *File) NewSection(name string) (*Section, error) {
+	if name == "" {
+		return nil, errors.New("ini: section name must not be empty")
+	}
+	if f.Section(name) != nil {
+		return nil, fmt.Errorf("ini: section %q already exists", name)
+	}
+	s := &Section{
+		file: f,
+		name: &node{kind: scalarNode, tag: ini_STR_TAG, value: name},
+		body: &node{kind: sectionNode},
+	}
+	if sameName(name, DEFAULT_SECTION, f.fold) {
+		f.doc.children = append([]*node{s.name, s.body}, f.doc.children...)
+	} else {
+		s.body.parents = []string{DEFAULT_SECTION}
+		f.doc.children = append(f.doc.children, s.name, s.body)
+	}
+	return s, nil
+}
+
+// DeleteSection removes every definition of the named section.
+func (f *File) DeleteSection(name string) {
+	children := f.doc.children[:0]
+	for i := 0; i < len(f.doc.children); i += 2 {
+		if !sameName(f.doc.children[i].value, name, f.fold) {
+			children = append(children, f.doc.children[i], f.doc.children[i+1])
+		}
+	}
+	f.doc.children = children
+}
+
+// WriteTo writes the document to w. It implements io.WriterTo.
+func (f *File) WriteTo(w io.Writer) (n int64, err error) {
+	cw := &countingWriter{w: w}
+	defer func() { n = cw.n }()
+	defer handleErr(&err)
+	e := newEncoderWithWriter(cw)
+	defer e.destroy()
+	ini_emitter_set_permissive_names(&e.emitter, f.names == PermissiveNames)
+	e.marshalDoc(reflect.ValueOf(f))
+	return
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// Name returns the name of the section.
+func (s *Section) Name() string {
+	return s.name.value
+}
+
+// Parent returns the name of the first section inherited with the
+// [name:parent] syntax, or "" if there is none. Every other section
+// implicitly inherits the default section.
+func (s *Section) Parent() string {
+	if parents := s.Parents(); len(parents) > 0 {
+		return parents[0]
+	}
+	return ""
+}
+
+// Parents returns the names of the sections inherited with the
+// [name:parent1, parent2] syntax, in order of precedence, or nil if there
+// are none.
+func (s *Section) Parents() []string {
+	return explicitParents(s.body)
+}
+
+// parents returns the sections whose keys are inherited by s, in order of
+// precedence.
+func (s *Section) parents() []*Section {
+	if sameName(s.name.value, DEFAULT_SECTION, s.file.fold) {
+		return nil
+	}
+	var parents []*Section
+	for _, name := range s.body.parents {
+		if parent := s.file.Section(name); parent != nil {
+			parents = append(parents, parent)
+		}
+	}
+	return parents
+}
+
+// explicitParents returns the parents of the section body, or nil if it
+// only inherits the default section.
+func explicitParents(body *node) []string {
+	if len(body.parents) == 1 && body.parents[0] == DEFAULT_SECTION {
+		return nil
+	}
+	return body.parents
+}
+
+// Keys returns the keys defined in the section itself, in order, leaving
+// out the inherited ones.
+func (s *Section) Keys() []*Key {
+	var keys []*Key
+	var walk func(prefix string, n *node)
+	walk = func(prefix string, n *node) {
+		for i := 0; i < len(n.children); i += 2 {
+			name := prefix + n.children[i].value
+			if value := n.children[i+1]; value.kind == mappingNode {
+				walk(name+".", value)
+			} else {
+				keys = append(keys, &Key{s, name, n.children[i], value})
+			}
+		}
+	}
+	walk("", s.body)
+	return keys
+}
+
+// Key returns the key with the given name, or nil if neither the section
+// nor the sections it inherits define it.
+func (s *Section) Key(name string) *Key {
+	return s.key(strings.Split(name, "."), name, make(map[*node]bool))
+}
+
+// key looks the key up in the section and then, depth first, in the
+// sections it inherits, in order of precedence.
+func (s *Section) key(path []string, name string, seen map[*node]bool) *Key {
+	if seen[s.body] {
+		return nil
+	}
+	seen[s.body] = true
+	if key, n := lookupNode(s.body, path, s.file.fold); n != nil && n.kind != mappingNode {
+		return &Key{s, name, key, n}
+	}
+	for _, parent := range s.parents() {
+		if k := parent.key(path, name, seen); k != nil {
+			return k
+		}
+	}
+	return nil
+}
+
+// HasKey reports whether the section or the sections it inherits define
+// the named key.
+func (s *Section) HasKey(name string) bool {
+	return s.Key(name) != nil
+}
+
+// SetValue sets the value of the named key in the section, adding the key
+// if the section does not define it yet. The value is interpreted the
+// same way as in a document, so "8080" reads back as an int.
+func (s *Section) SetValue(name, value string) error {
+	path := strings.Split(name, ".")
+	for _, segment := range path {
+		if segment == "" {
+			return fmt.Errorf("ini: invalid key name %q", name)
+		}
+	}
+	n := s.body
+	for i, segment := range path {
+		j := indexNode(n, segment, s.file.fold)
+		if j < 0 {
+			// Untagged, the key is written plain as in a document.
+			n.children = append(n.children, &node{kind: scalarNode, value: segment}, nil)
+			j = len(n.children) - 2
+		}
+		if i == len(path)-1 {
+			v := &node{kind: scalarNode, value: value}
+			if old := n.children[j+1]; old != nil {
+				v.lineComment = lastValue(old).lineComment
+			}
+			n.children[j+1] = v
+		} else {
+			if n.children[j+1] == nil || n.children[j+1].kind != mappingNode {
+				// As in a document, the later key replaces the value.
+				n.children[j+1] = &node{kind: mappingNode}
+			}
+			n = n.children[j+1]
+		}
+	}
+	return nil
+}
+
+// Comment returns the comment lines written above the section header,
+// markers included.
+func (s *Section) Comment() string {
+	return s.name.headComment
+}
+
+// SetComment sets the comment lines written above the section header.
+// Lines not starting with '#' or ';' are prefixed with "; ", and an empty
+// comment removes it.
+func (s *Section) SetComment(comment string) {
+	s.name.headComment = formatComment(comment)
+}
+
+// LineComment returns the comment written after the section header on the
+// same line, marker included.
+func (s *Section) LineComment() string {
+	return s.body.lineComment
+}
+
+// SetLineComment sets the comment written after the section header on the
+// same line. It is prefixed with "; " unless it starts with '#' or ';'.
+func (s *Section) SetLineComment(comment string) {
+	s.body.lineComment = formatLineComment(comment)
+}
+
+// DeleteKey removes the named key from the section. Keys of inherited
+// sections are left alone.
+func (s *Section) DeleteKey(name string) {
+	deleteNode(s.body, strings.Split(name, "."), s.file.fold)
+}
+
+// lookupNode returns the key and the value at path in the section or
+// mapping node n.
+func lookupNode(n *node, path []string, fold bool) (key, value *node) {
+	for _, segment := range path {
+		if n.kind != sectionNode && n.kind != mappingNode {
+			return nil, nil
+		}
+		i := indexNode(n, segment, fold)
+		if i < 0 {
+			return nil, nil
+		}
+		key, n = n.children[i], n.children[i+1]
+	}
+	return key, n
+}
+
+// indexNode returns the index of the key named name in the children of n,
+// or -1 if there is none. The last definition wins.
+func indexNode(n *node, name string, fold bool) int {
+	for i := len(n.children) - 2; i >= 0; i -= 2 {
+		if sameName(n.children[i].value, name, fold) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sameName reports whether a and b are the same key or section name,
+// ignoring case if fold is set.
+func sameName(a, b string, fold bool) bool {
+	if fold {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
+
+// deleteNode removes the value at path from n, along with the mappings
+// that are left empty. It reports whether n itself was left empty.
+func deleteNode(n *node, path []string, fold bool) bool {
+	i := indexNode(n, path[0], fold)
+	if i < 0 {
+		return false
+	}
+	value := n.children[i+1]
+	if len(path) == 1 || value.kind == mappingNode && deleteNode(value, path[1:], fold) {
+		n.children = append(n.children[:i], n.children[i+2:]...)
+	}
+	return len(n.children) == 0
+}
+
+// formatComment adds a comment marker to each line of comment lacking one.
+func formatComment(comment string) string {
+	if comment == "" {
+		return ""
+	}
+	lines := strings.Split(comment, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = formatLineComment(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatLineComment turns comment into a single line comment with a
+// comment marker.
+func formatLineComment(comment string) string {
+	comment = strings.Replace(comment, "\n", " ", -1)
+	if comment == "" || comment[0] == '#' || comment[0] == ';' {
+		return comment
+	}
+	return "; " + comment
+}
+
+// Section returns the section defining the key, which is an inherited
+// section for inherited keys.
+func (k *Key) Section() *Section {
+	return k.section
+}
+
+// Name returns the full dotted name of the key.
+func (k *Key) Name() string {
+	return k.name
+}
+
+// Value returns the value of the key as written, without quotes. It is
+// the last value of a repeated key or of a key[] array key.
+func (k *Key) Value() string {
+	return lastValue(k.node).value
+}
+
+// Values returns every value of a repeated key or of a key[] array key,
+// in order, or the single value of another key.
+func (k *Key) Values() []string {
+	if k.node.kind != sequenceNode {
+		return []string{k.node.value}
+	}
+	values := make([]string, len(k.node.children))
+	for i, n := range k.node.children {
+		values[i] = n.value
+	}
+	return values
+}
+
+// String returns the value of the key. It is the same as Value.
+func (k *Key) String() string {
+	return k.Value()
+}
+
+// Comment returns the comment lines written above the key, markers
+// included.
+func (k *Key) Comment() string {
+	return k.key.headComment
+}
+
+// SetComment sets the comment lines written above the key, the same way
+// as Section.SetComment.
+func (k *Key) SetComment(comment string) {
+	k.key.headComment = formatComment(comment)
+}
+
+// LineComment returns the comment written after the value on the same
+// line, marker included.
+func (k *Key) LineComment() string {
+	return lastValue(k.node).lineComment
+}
+
+// SetLineComment sets the comment written after the value on the same
+// line, the same way as Section.SetLineComment.
+func (k *Key) SetLineComment(comment string) {
+	lastValue(k.node).lineComment = formatLineComment(comment)
+}
+
+// Int returns the value of the key as an int.
+func (k *Key) Int() (v int, err error) {
+	err = k.decode(&v)
+	return v, err
+}
+
+// Int64 returns the value of the key as an int64.
+func (k *Key) Int64() (v int64, err error) {
+	err = k.decode(&v)
+	return v, err
+}
+
+// Float64 returns the value of the key as a float64.
+func (k *Key) Float64() (v float64, err error) {
+	err = k.decode(&v)
+	return v, err
+}
+
+// Bool returns the value of the key as a bool. Besides true and false,
+// the values y, yes, on, n, no and off are recognized.
+func (k *Key) Bool() (v bool, err error) {
+	err = k.decode(&v)
+	return v, err
+}
+
+// Duration returns the value of the key as a time.Duration, written
+// either in time.ParseDuration format or as a number of nanoseconds.
+func (k *Key) Duration() (v time.Duration, err error) {
+	err = k.decode(&v)
+	return v, err
+}
+
+// decode unmarshals the value of the key into out, which must be a
+// pointer, the same way Unmarshal would.
+func (k *Key) decode(out interface{}) (err error) {
+	defer handleErr(&err)
+	d := newDecoder()
+	d.unmarshal(k.node, reflect.ValueOf(out).Elem())
+	if len(d.terrors) > 0 {
+		return newTypeError(d.terrors)
+	}
+	return nil
+}
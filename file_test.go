@@ -0,0 +1,478 @@
+package ini_test
+
+import (
+	"bytes"
+	. "gopkg.in/check.v1"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go-ini"
+)
+
+var fileData = `name = app
+debug = on
+
+[server]
+host = localhost
+port = 8080
+timeout = 5s
+tls.cert = "cert.pem"
+tls.key = key.pem
+
+[staging:server]
+host = staging
+quoted = "8080"
+`
+
+func (s *S) TestFileSections(c *C) {
+	f, err := ini.Load([]byte(fileData))
+	c.Assert(err, IsNil)
+	var names []string
+	for _, section := range f.Sections() {
+		names = append(names, section.Name())
+	}
+	c.Assert(names, DeepEquals, []string{"default", "server", "staging"})
+	c.Assert(f.Section("missing"), IsNil)
+	c.Assert(f.Section("server").Parent(), Equals, "")
+	c.Assert(f.Section("staging").Parent(), Equals, "server")
+
+	var keys []string
+	for _, key := range f.Section("server").Keys() {
+		keys = append(keys, key.Name()+"="+key.Value())
+	}
+	c.Assert(keys, DeepEquals, []string{"host=localhost", "port=8080", "timeout=5s", "tls.cert=cert.pem", "tls.key=key.pem"})
+	c.Assert(f.Section("staging").Keys(), HasLen, 2)
+}
+
+func (s *S) TestFileKeys(c *C) {
+	f, err := ini.Load([]byte(fileData))
+	c.Assert(err, IsNil)
+	staging := f.Section("staging")
+
+	c.Assert(staging.Key("host").String(), Equals, "staging")
+	c.Assert(staging.Key("host").Section().Name(), Equals, "staging")
+	c.Assert(staging.Key("tls.key").Value(), Equals, "key.pem")
+	c.Assert(staging.Key("tls.key").Section().Name(), Equals, "server")
+	c.Assert(staging.Key("name").Section().Name(), Equals, "default")
+	c.Assert(staging.Key("tls"), IsNil)
+	c.Assert(staging.Key("missing"), IsNil)
+	c.Assert(staging.HasKey("port"), Equals, true)
+	c.Assert(f.Section("server").HasKey("quoted"), Equals, false)
+
+	port, err := staging.Key("port").Int()
+	c.Assert(err, IsNil)
+	c.Assert(port, Equals, 8080)
+	port64, err := staging.Key("port").Int64()
+	c.Assert(err, IsNil)
+	c.Assert(port64, Equals, int64(8080))
+	ratio, err := staging.Key("port").Float64()
+	c.Assert(err, IsNil)
+	c.Assert(ratio, Equals, 8080.0)
+	debug, err := staging.Key("debug").Bool()
+	c.Assert(err, IsNil)
+	c.Assert(debug, Equals, true)
+	timeout, err := staging.Key("timeout").Duration()
+	c.Assert(err, IsNil)
+	c.Assert(timeout, Equals, 5*time.Second)
+
+	_, err = staging.Key("quoted").Int()
+	c.Assert(err, ErrorMatches, "ini: unmarshal errors:\n  line 13: cannot unmarshal str `8080` into int")
+	_, err = staging.Key("host").Bool()
+	c.Assert(err, ErrorMatches, "ini: unmarshal errors:\n  line 12: cannot unmarshal str `staging` into bool")
+}
+
+func (s *S) TestFileEdit(c *C) {
+	f, err := ini.Load([]byte(fileData))
+	c.Assert(err, IsNil)
+
+	server := f.Section("server")
+	c.Assert(server.SetValue("port", "9090"), IsNil)
+	c.Assert(server.SetValue("tls.ca", "ca.pem"), IsNil)
+	c.Assert(server.SetValue("y", "=x"), IsNil)
+	c.Assert(server.SetValue("a..b", "x"), ErrorMatches, `ini: invalid key name "a..b"`)
+	server.DeleteKey("timeout")
+	server.DeleteKey("tls.cert")
+	server.DeleteKey("missing.key")
+	f.Section("default").DeleteKey("debug")
+	f.DeleteSection("staging")
+
+	cache, err := f.NewSection("cache")
+	c.Assert(err, IsNil)
+	c.Assert(cache.SetValue("size", "10"), IsNil)
+	_, err = f.NewSection("cache")
+	c.Assert(err, ErrorMatches, `ini: section "cache" already exists`)
+
+	port, err := f.Section("server").Key("port").Int()
+	c.Assert(err, IsNil)
+	c.Assert(port, Equals, 9090)
+	c.Assert(f.Section("cache").Key("name").Value(), Equals, "app")
+
+	var buf bytes.Buffer
+	n, err := f.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, int64(buf.Len()))
+	c.Assert(buf.String(), Equals, "name = app\n\n"+
+		"[server]\nhost = localhost\nport = 9090\ntls.key = key.pem\ntls.ca = ca.pem\ny = \"=x\"\n\n"+
+		"[cache]\nsize = 10\n")
+}
+
+func (s *S) TestFileSetValueRoundTrip(c *C) {
+	f, err := ini.Load()
+	c.Assert(err, IsNil)
+	section, err := f.NewSection("s")
+	c.Assert(err, IsNil)
+	c.Assert(section.SetValue("on.x", "c"), IsNil)
+	c.Assert(section.SetValue("a.1", "d"), IsNil)
+	c.Assert(section.SetValue("null", "e"), IsNil)
+
+	var buf bytes.Buffer
+	_, err = f.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "[s]\non.x = c\na.1 = d\nnull = e\n")
+
+	f, err = ini.Load(buf.Bytes())
+	c.Assert(err, IsNil)
+	c.Assert(f.Section("s").Key("on.x").Value(), Equals, "c")
+	c.Assert(f.Section("s").Key("a.1").Value(), Equals, "d")
+	c.Assert(f.Section("s").Key("null").Value(), Equals, "e")
+}
+
+func (s *S) TestFileNew(c *C) {
+	f, err := ini.Load()
+	c.Assert(err, IsNil)
+	c.Assert(f.Sections(), HasLen, 0)
+	section, err := f.NewSection("section")
+	c.Assert(err, IsNil)
+	c.Assert(section.SetValue("a", "1"), IsNil)
+	def, err := f.NewSection("default")
+	c.Assert(err, IsNil)
+	c.Assert(def.SetValue("b", "2"), IsNil)
+	c.Assert(section.Key("b").Value(), Equals, "2")
+
+	var buf bytes.Buffer
+	_, err = f.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "b = 2\n\n[section]\na = 1\n")
+}
+
+func (s *S) TestFileRoundTrip(c *C) {
+	f, err := ini.Load([]byte(fileData))
+	c.Assert(err, IsNil)
+	var buf bytes.Buffer
+	_, err = f.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, `name = app
+debug = on
+
+[server]
+host = localhost
+port = 8080
+timeout = 5s
+tls.cert = cert.pem
+tls.key = key.pem
+
+[staging:server]
+host = staging
+quoted = "8080"
+`)
+
+	var want, got interface{}
+	c.Assert(ini.Unmarshal([]byte(fileData), &want), IsNil)
+	c.Assert(ini.Unmarshal(buf.Bytes(), &got), IsNil)
+	c.Assert(got, DeepEquals, want)
+}
+
+func (s *S) TestFileLoadError(c *C) {
+	_, err := ini.Load([]byte("[a:b]\nc = d\n"))
+	c.Assert(err, ErrorMatches, `ini: line 1: section "a" inherits undefined section "b"`)
+}
+
+type closeRecorder struct {
+	*strings.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func (s *S) TestFileLoadSources(c *C) {
+	dir := c.MkDir()
+	site := filepath.Join(dir, "site.ini")
+	err := ioutil.WriteFile(site, []byte("[server]\nport = 8081\ntls.key = site.pem\n[dev:server]\ndebug = on\n"), 0644)
+	c.Assert(err, IsNil)
+	local := &closeRecorder{Reader: strings.NewReader("name = local\n[dev]\nport = 9000\n[extra:dev]\n")}
+
+	f, err := ini.Load([]byte(fileData), site, local)
+	c.Assert(err, IsNil)
+	c.Assert(local.closed, Equals, true)
+
+	var names []string
+	for _, section := range f.Sections() {
+		names = append(names, section.Name())
+	}
+	c.Assert(names, DeepEquals, []string{"default", "server", "staging", "dev", "extra"})
+	c.Assert(f.Section("default").Key("name").Value(), Equals, "local")
+	c.Assert(f.Section("default").Key("debug").Value(), Equals, "on")
+	c.Assert(f.Section("server").Key("port").Value(), Equals, "8081")
+	c.Assert(f.Section("server").Key("host").Value(), Equals, "localhost")
+	c.Assert(f.Section("server").Key("tls.key").Value(), Equals, "site.pem")
+	c.Assert(f.Section("server").Key("tls.cert").Value(), Equals, "cert.pem")
+	c.Assert(f.Section("staging").Key("tls.key").Value(), Equals, "site.pem")
+	c.Assert(f.Section("dev").Parent(), Equals, "server")
+	c.Assert(f.Section("dev").Key("port").Value(), Equals, "9000")
+	c.Assert(f.Section("extra").Key("host").Value(), Equals, "localhost")
+
+	var buf bytes.Buffer
+	_, err = f.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, `name = local
+debug = on
+
+[server]
+host = localhost
+port = 8081
+timeout = 5s
+tls.cert = cert.pem
+tls.key = site.pem
+
+[staging:server]
+host = staging
+quoted = "8080"
+
+[dev:server]
+debug = on
+port = 9000
+
+[extra:dev]
+`)
+}
+
+func (s *S) TestFileAppend(c *C) {
+	f, err := ini.Load()
+	c.Assert(err, IsNil)
+	c.Assert(f.Append([]byte("[a]\nx = 1\ny = 2\n[a]\ny = 3\n")), IsNil)
+	c.Assert(f.Append(strings.NewReader("[b:a]\nz = 4\n")), IsNil)
+	c.Assert(f.Sections(), HasLen, 2)
+	c.Assert(f.Section("a").Key("y").Value(), Equals, "3")
+	c.Assert(f.Section("b").Key("x").Value(), Equals, "1")
+}
+
+func (s *S) TestFileLoadSourceErrors(c *C) {
+	_, err := ini.Load(42)
+	c.Assert(err, ErrorMatches, "ini: cannot load a source of type int")
+	_, err = ini.Load(filepath.Join(c.MkDir(), "missing.ini"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+	_, err = ini.Load([]byte("a = 1\n"), []byte("[s:missing]\n"))
+	c.Assert(err, ErrorMatches, `ini: line 1: section "s" inherits undefined section "missing"`)
+
+	f, err := ini.Load([]byte("[s:later]\n"), []byte("[later]\na = 1\n"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Section("s").Key("a").Value(), Equals, "1")
+	_, err = ini.Load([]byte("[a:b]\n"), []byte("[b:a]\n"))
+	c.Assert(err, ErrorMatches, "ini: line 1: section 'a' and line 1: section 'b' inherit each other")
+}
+
+func (s *S) TestFileNameGrammar(c *C) {
+	data := "[remote \"origin\"]\nurl = git\n"
+	f, err := ini.Load([]byte(data))
+	c.Assert(err, IsNil)
+	c.Assert(f.Section(`remote "origin"`).Key("url").Value(), Equals, "git")
+	var buf bytes.Buffer
+	_, err = f.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, data)
+
+	data = "[Network Settings]\nhost = localhost\n"
+	_, err = ini.Load([]byte(data))
+	c.Assert(err, ErrorMatches, "ini: line 1: found a blank inside the section key")
+	f, err = ini.Load()
+	c.Assert(err, IsNil)
+	f.SetNameGrammar(ini.PermissiveNames)
+	c.Assert(f.Append([]byte(data)), IsNil)
+	c.Assert(f.Section("Network Settings").Key("host").Value(), Equals, "localhost")
+	buf.Reset()
+	_, err = f.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, data)
+}
+
+func (s *S) TestFileArrays(c *C) {
+	data := "server = a ; first\nserver = b\nport[] = 80\nport[] = 443\n"
+	f, err := ini.Load([]byte(data))
+	c.Assert(err, IsNil)
+	def := f.Section("default")
+	c.Assert(def.Key("server").Value(), Equals, "b")
+	c.Assert(def.Key("server").Values(), DeepEquals, []string{"a", "b"})
+	c.Assert(def.Key("port").Values(), DeepEquals, []string{"80", "443"})
+	var buf bytes.Buffer
+	_, err = f.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, data)
+}
+
+func (s *S) TestFileDuplicatePolicy(c *C) {
+	f, err := ini.Load()
+	c.Assert(err, IsNil)
+	f.SetDuplicatePolicy(ini.DuplicateError)
+	err = f.Append([]byte("[s]\nk = 1\nk = 2\n"))
+	c.Assert(err, ErrorMatches, "ini: line 3: key 'k' is already defined at line 2")
+	c.Assert(f.Append([]byte("[s]\nk = 1\n"), []byte("[s]\nk = 2\n")), IsNil)
+	c.Assert(f.Section("s").Key("k").Value(), Equals, "2")
+}
+
+func (s *S) TestFileCaseInsensitive(c *C) {
+	f, err := ini.Load()
+	c.Assert(err, IsNil)
+	f.SetCaseInsensitive(true)
+	c.Assert(f.Append([]byte("[Server]\nHost = a\n[Staging:SERVER]\n"), []byte("[server]\nhost = b\n")), IsNil)
+	c.Assert(f.Sections(), HasLen, 2)
+	c.Assert(f.Section("SERVER").Name(), Equals, "Server")
+	c.Assert(f.Section("staging").Key("HOST").Value(), Equals, "b")
+	f.Section("server").DeleteKey("host")
+	c.Assert(f.Section("Staging").HasKey("host"), Equals, false)
+
+	// A [DEFAULT] section is the default section.
+	c.Assert(f.Append([]byte("[s]\nk = 1\n"), []byte("[DEFAULT]\nname = app\n")), IsNil)
+	c.Assert(f.Sections()[0].Name(), Equals, "DEFAULT")
+	c.Assert(f.Section("s").Key("name").Value(), Equals, "app")
+	c.Assert(f.Section("default").HasKey("k"), Equals, false)
+}
+
+func (s *S) TestFileValidate(c *C) {
+	f, err := ini.Load([]byte("[server]\nport = 0\nmode = fast\nname = web\n"))
+	c.Assert(err, IsNil)
+	server := f.Section("server")
+	err = server.Validate(map[string]string{
+		"port": "required,min=1,max=65535",
+		"host": "required",
+		"mode": "oneof=slow|safe",
+		"name": "min=2,regexp=^[a-z]+$",
+	})
+	c.Assert(err, ErrorMatches, "ini: validation errors:\n"+
+		"  line 1: key 'host' in section 'server' is required\n"+
+		"  line 2: key 'port' in section 'server' must be at least 1\n"+
+		"  line 3: key 'mode' in section 'server' must be one of slow\\|safe")
+	c.Assert(err.(*ini.ValidationError).Violations[1], Equals, ini.Violation{"server", "port", 2, "must be at least 1"})
+	c.Assert(server.Key("mode").Validate("regexp=^f"), IsNil)
+	c.Assert(server.Key("mode").Validate("bogus"), ErrorMatches, `ini: unsupported rule "bogus"`)
+}
+
+func (s *S) TestFileTolerant(c *C) {
+	f, err := ini.Load()
+	c.Assert(err, IsNil)
+	f.SetTolerant(true)
+	err = f.Append([]byte("[a]\nk = 1\n[b c]\nl = 2\n"), []byte("[d]\nm = 'x\no\n[e]\nn = 3\n"))
+	c.Assert(err, ErrorMatches, "ini: syntax errors:\n"+
+		"  line 3: found a blank inside the section key\n"+
+		"  line 2: found unexpected line break\n"+
+		"  line 3: did not find expected <value> or <map>")
+	c.Assert(err.(*ini.ParseError).Errors, HasLen, 3)
+	c.Assert(f.Section("a").HasKey("l"), Equals, false)
+	c.Assert(f.Section("b c"), IsNil)
+	c.Assert(f.Section("d").HasKey("m"), Equals, false)
+	c.Assert(f.Section("d").HasKey("o"), Equals, false)
+	c.Assert(f.Section("e").Key("n").Value(), Equals, "3")
+
+	// The broken lines are not written back as keys.
+	var buf bytes.Buffer
+	_, err = f.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "[a]\nk = 1\n\n[d]\n\n[e]\nn = 3\n")
+}
+
+var commentData = `; Application settings
+name = app ; the name
+color = #fff
+
+# Server settings
+# shared by every environment
+[server] ; production
+
+; Listen address
+host = localhost
+tls.cert = "cert.pem" # relative to the working directory
+tls.key = key.pem
+
+; end of file
+`
+
+func (s *S) TestFileComments(c *C) {
+	f, err := ini.Load([]byte(commentData))
+	c.Assert(err, IsNil)
+	def, server := f.Section("default"), f.Section("server")
+	c.Assert(def.Key("name").Comment(), Equals, "; Application settings")
+	c.Assert(def.Key("name").Value(), Equals, "app")
+	c.Assert(def.Key("name").LineComment(), Equals, "; the name")
+	c.Assert(def.Key("color").Value(), Equals, "#fff")
+	c.Assert(def.Key("color").LineComment(), Equals, "")
+	c.Assert(server.Comment(), Equals, "# Server settings\n# shared by every environment")
+	c.Assert(server.LineComment(), Equals, "; production")
+	c.Assert(server.Key("host").Comment(), Equals, "; Listen address")
+	c.Assert(server.Key("tls.cert").LineComment(), Equals, "# relative to the working directory")
+	c.Assert(server.Key("tls.key").Comment(), Equals, "")
+
+	var buf bytes.Buffer
+	_, err = f.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, `; Application settings
+name = app ; the name
+color = "#fff"
+
+# Server settings
+# shared by every environment
+[server] ; production
+; Listen address
+host = localhost
+tls.cert = cert.pem # relative to the working directory
+tls.key = key.pem
+
+; end of file
+`)
+
+	data, err := ini.Marshal(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(data), Equals, buf.String())
+}
+
+func (s *S) TestFileSetComments(c *C) {
+	f, err := ini.Load([]byte("[server]\nhost = localhost\n"))
+	c.Assert(err, IsNil)
+	server := f.Section("server")
+	server.SetComment("Server settings\n\n# see the manual")
+	server.SetLineComment("production")
+	host := server.Key("host")
+	host.SetComment("# Listen address")
+	host.SetLineComment("or\nan IP")
+	c.Assert(server.SetValue("host", "example.com"), IsNil)
+	c.Assert(server.SetValue("url", "a #b"), IsNil)
+
+	var buf bytes.Buffer
+	_, err = f.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "; Server settings\n\n# see the manual\n[server] ; production\n"+
+		"# Listen address\nhost = example.com ; or an IP\nurl = \"a #b\"\n")
+
+	host.SetComment("")
+	c.Assert(server.Key("host").Comment(), Equals, "")
+}
+
+func (s *S) TestFileMultipleInheritance(c *C) {
+	f, err := ini.Load([]byte("[prod]\nhost = prod\n[eu]\nhost = eu\nlang = de\n[prod-eu : prod, eu]\n"))
+	c.Assert(err, IsNil)
+	section := f.Section("prod-eu")
+	c.Assert(section.Parent(), Equals, "prod")
+	c.Assert(section.Parents(), DeepEquals, []string{"prod", "eu"})
+	c.Assert(f.Section("prod").Parents(), IsNil)
+	c.Assert(section.Key("host").Value(), Equals, "prod")
+	c.Assert(section.Key("lang").Section().Name(), Equals, "eu")
+
+	var buf bytes.Buffer
+	_, err = f.WriteTo(&buf)
+	c.Assert(err, IsNil)
+	c.Assert(buf.String(), Equals, "[prod]\nhost = prod\n\n[eu]\nhost = eu\nlang = de\n\n[prod-eu:prod, eu]\n")
+}
@@ -3,6 +3,8 @@ package ini
 import (
 	"errors"
 	"fmt"
+	"io"
+	"os"
 	"reflect"
 	"strings"
 	"sync"
@@ -64,7 +66,67 @@ func Unmarshal(in []byte, out interface{}) (err error) {
 		d.unmarshal(node, v)
 	}
 	if len(d.terrors) > 0 {
-		return &TypeError{d.terrors}
+		return newTypeError(d.terrors)
+	}
+	if violations := d.validate(); len(violations) > 0 {
+		return &ValidationError{violations}
+	}
+	return nil
+}
+
+// A Decoder reads and decodes an INI document from an input stream.
+type Decoder struct {
+	parser        *parser
+	interpolation bool
+	lookupEnv     func(string) (string, bool)
+	delimiter     string
+	knownFields   bool
+}
+
+// NewDecoder returns a new decoder that reads from r.
+//
+// The decoder introduces its own buffering and reads r incrementally, so
+// the input does not have to be loaded into memory first.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		parser: newParserFromReader(r),
+	}
+}
+
+// Decode reads the INI document from its input and stores it in the value
+// pointed to by v. An INI stream holds a single document, so every call
+// after the first one returns io.EOF.
+//
+// See the documentation for Unmarshal for details about the conversion
+// of INI into a Go value.
+func (dec *Decoder) Decode(v interface{}) (err error) {
+	defer handleErr(&err)
+	if dec.parser.doneInit && dec.parser.event.typ == ini_DOCUMENT_END_EVENT {
+		return io.EOF
+	}
+	d := newDecoder()
+	d.interpolation = dec.interpolation
+	d.lookupEnv = dec.lookupEnv
+	d.delimiter = dec.delimiter
+	d.knownFields = dec.knownFields
+	d.fold = dec.parser.fold
+	dec.parser.init()
+	node := dec.parser.parse()
+	if node != nil {
+		out := reflect.ValueOf(v)
+		if out.Kind() == reflect.Ptr && !out.IsNil() {
+			out = out.Elem()
+		}
+		d.unmarshal(node, out)
+	}
+	if err := dec.parser.syntaxErrors(); err != nil {
+		return err
+	}
+	if len(d.terrors) > 0 {
+		return newTypeError(d.terrors)
+	}
+	if violations := d.validate(); len(violations) > 0 {
+		return &ValidationError{violations}
 	}
 	return nil
 }
@@ -73,12 +135,244 @@ func Marshal(in interface{}) (out []byte, err error) {
 	defer handleErr(&err)
 	e := newEncoder()
 	defer e.destroy()
-	e.marshal(reflect.ValueOf(in))
-	e.finish()
+	e.marshalDoc(reflect.ValueOf(in))
+	out = e.out
+	return
+}
+
+// MarshalDepth works like Marshal, but fails instead of writing a dotted
+// key made of more than depth segments. Sections do not count towards the
+// depth, so a depth of 1 forbids dotted keys altogether. A depth of zero
+// or less means no limit.
+func MarshalDepth(in interface{}, depth int) (out []byte, err error) {
+	defer handleErr(&err)
+	e := newEncoder()
+	defer e.destroy()
+	e.maxDepth = depth
+	e.marshalDoc(reflect.ValueOf(in))
 	out = e.out
 	return
 }
 
+// SetInterpolation sets whether the decoder replaces the ${key} and
+// ${section.key} references in values with the values of the keys they
+// refer to. A ${key} reference is looked up in the section holding the
+// value, including the keys it inherits, and then in the default section,
+// and $${ stands for a literal ${. Referring to a missing key, or to a
+// value referring back to the first one, is an error. These references
+// are left alone unless SetInterpolation is called.
+func (dec *Decoder) SetInterpolation(interpolation bool) {
+	dec.interpolation = interpolation
+}
+
+// ExpandEnv makes the decoder replace ${env:NAME} references in values
+// with the value of the environment variable NAME, or with default for
+// ${env:NAME:-default} if the variable is unset or empty. Referring to an
+// unset variable without a default is an error. These references are
+// left alone unless ExpandEnv or SetLookupEnv is called.
+func (dec *Decoder) ExpandEnv() {
+	dec.lookupEnv = os.LookupEnv
+}
+
+// SetLookupEnv is like ExpandEnv, but looks the environment variables up
+// with the given function instead of os.LookupEnv.
+func (dec *Decoder) SetLookupEnv(lookup func(name string) (string, bool)) {
+	dec.lookupEnv = lookup
+}
+
+// DisallowUnknownFields makes the decoder return an error when a key or
+// a section of the document matches no field of the struct it is decoded
+// into. Each one is reported with its section, its full dotted key and
+// its line. Keys decoded into maps are never unknown.
+func (dec *Decoder) DisallowUnknownFields() {
+	dec.knownFields = true
+}
+
+// SetCaseInsensitive sets whether keys and section names match regardless
+// of case: when decoding into struct fields, when looking up inherited
+// sections and references, and when merging keys and sections defined
+// more than once.
+func (dec *Decoder) SetCaseInsensitive(insensitive bool) {
+	dec.parser.fold = insensitive
+}
+
+// SetTolerant sets whether the decoder goes on past the syntax errors of
+// the document. A tolerant decoder skips the line of each error, or the
+// rest of the section header or key it was in the middle of, and decodes
+// the rest of the document. The keys following a broken section header
+// are skipped as well, up to the next section header, since the section
+// they belong to is unknown. It then returns a ParseError holding every
+// syntax error found.
+func (dec *Decoder) SetTolerant(tolerant bool) {
+	ini_parser_set_tolerant(&dec.parser.parser, tolerant)
+}
+
+// SetContinuation sets whether values go on over continuation lines. A
+// value ending with a '\\' then goes on over the next line, leaving out
+// the '\\', the line break and the indentation of the next line, and a
+// value goes on over the lines indented deeper than its key, joined by
+// line breaks, up to a blank line:
+//
+//	pem =
+//	    -----BEGIN CERTIFICATE-----
+//	    MIIB...
+//	    -----END CERTIFICATE-----
+//
+// Without continuation lines, which is the default, a value ends with
+// its line.
+func (dec *Decoder) SetContinuation(continuation bool) {
+	ini_parser_set_continuation(&dec.parser.parser, continuation)
+}
+
+// SetDelimiter makes the decoder split the values decoded into a slice on
+// sep, trimming the blanks around each item, so that "a, b, c" decodes
+// into []string{"a", "b", "c"} with a "," delimiter. A '\' escapes a
+// delimiter or another '\' inside an item, and an empty quoted value, as
+// in `k = ""`, makes an empty slice. Without a delimiter, such a value
+// makes a slice of a single item. The repeated keys and the
+// key[] array keys of a section are decoded into slices either way.
+func (dec *Decoder) SetDelimiter(sep string) {
+	dec.delimiter = sep
+}
+
+// DuplicatePolicy selects what happens to a key defined more than once in
+// a section, or to a section defined more than once in a document. Array
+// keys such as key[] are never duplicates: each definition adds a value
+// to the array, whatever the policy.
+type DuplicatePolicy int
+
+const (
+	// DuplicateLastWins makes the last value of a repeated key win, unless
+	// the key is decoded into a slice, which gets every value. A repeated
+	// section is decoded after the previous ones. This is the default.
+	DuplicateLastWins DuplicatePolicy = iota
+	// DuplicateFirstWins keeps the first definition and ignores the
+	// others.
+	DuplicateFirstWins
+	// DuplicateError makes a repeated key or section an error that
+	// reports the lines of both definitions.
+	DuplicateError
+	// DuplicateAccumulate turns the values of a repeated key into a
+	// list, as for key[] array keys, and merges a repeated section into
+	// the first one.
+	DuplicateAccumulate
+)
+
+// SetDuplicatePolicy sets what happens to the keys and sections defined
+// more than once.
+func (dec *Decoder) SetDuplicatePolicy(policy DuplicatePolicy) {
+	dec.parser.duplicates = policy
+}
+
+// NameGrammar selects the characters allowed in section names. Either
+// grammar accepts a git style quoted subsection at the end of a name, as
+// in [remote "origin"], which names the section "origin" nested in the
+// section "remote".
+type NameGrammar int
+
+const (
+	// StrictNames allows dotted segments of alphanumerical characters,
+	// '_' and '-', as in [database.primary]. This is the default.
+	StrictNames NameGrammar = iota
+	// PermissiveNames allows any character but ']', as in
+	// [Network Settings] or [日本語]. Dots still name nested sections,
+	// but ':' and ',' are part of the name, so sections cannot inherit
+	// others.
+	PermissiveNames
+)
+
+// SetNameGrammar sets the characters allowed in section names.
+func (dec *Decoder) SetNameGrammar(g NameGrammar) {
+	ini_parser_set_permissive_names(&dec.parser.parser, g == PermissiveNames)
+}
+
+// LineBreak selects the line break written by an Encoder.
+type LineBreak int
+
+const (
+	LineBreakLN   LineBreak = iota // Unix style, "\n". This is the default.
+	LineBreakCR                    // Classic Mac style, "\r".
+	LineBreakCRLN                  // DOS and Windows style, "\r\n".
+)
+
+// An Encoder writes an INI document to an output stream.
+type Encoder struct {
+	encoder *encoder
+}
+
+// NewEncoder returns a new encoder that writes to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{
+		encoder: newEncoderWithWriter(w),
+	}
+}
+
+// Encode writes the INI encoding of v to the stream. An INI stream holds
+// a single document, so Encode may only be called once.
+//
+// See the documentation for Marshal for details about the conversion of
+// Go values to INI.
+func (e *Encoder) Encode(v interface{}) (err error) {
+	defer handleErr(&err)
+	if e.encoder.doneInit {
+		failf("an INI stream holds a single document; Encode may only be called once")
+	}
+	e.encoder.marshalDoc(reflect.ValueOf(v))
+	return nil
+}
+
+// SetLineBreak sets the line break written at the end of every line.
+func (e *Encoder) SetLineBreak(lb LineBreak) {
+	switch lb {
+	case LineBreakCR:
+		ini_emitter_set_break(&e.encoder.emitter, ini_CR_BREAK)
+	case LineBreakCRLN:
+		ini_emitter_set_break(&e.encoder.emitter, ini_CRLN_BREAK)
+	default:
+		ini_emitter_set_break(&e.encoder.emitter, ini_LN_BREAK)
+	}
+}
+
+// ArrayStyle selects how an Encoder writes slices and arrays.
+type ArrayStyle int
+
+const (
+	RepeatedKeys    ArrayStyle = iota // The key repeated for every item. This is the default.
+	BracketKeys                       // The key[] array key repeated for every item.
+	DelimitedValues                   // A single key with the items joined by the delimiter.
+)
+
+// SetArrayStyle sets how slices and arrays are written.
+func (e *Encoder) SetArrayStyle(style ArrayStyle) {
+	e.encoder.arrayStyle = style
+}
+
+// SetDelimiter sets the delimiter joining the items of slices and arrays,
+// followed by a space, with the DelimitedValues array style. It is ","
+// by default. The delimiters and the '\' inside the items are escaped with
+// a '\', the way Decoder.SetDelimiter reads them back.
+func (e *Encoder) SetDelimiter(sep string) {
+	e.encoder.delimiter = sep
+}
+
+// SetNameGrammar sets the characters allowed in the section names the
+// encoder writes.
+func (e *Encoder) SetNameGrammar(g NameGrammar) {
+	ini_emitter_set_permissive_names(&e.encoder.emitter, g == PermissiveNames)
+}
+
+// SetUnicode sets whether non-ASCII characters are written as they are,
+// which is the default, or escaped inside double-quoted values.
+func (e *Encoder) SetUnicode(unicode bool) {
+	ini_emitter_set_unicode(&e.encoder.emitter, unicode)
+}
+
+// SetMaxDepth limits the number of segments of a dotted key, just like
+// MarshalDepth does.
+func (e *Encoder) SetMaxDepth(depth int) {
+	e.encoder.maxDepth = depth
+}
+
 func handleErr(err *error) {
 	if v := recover(); v != nil {
 		if e, ok := v.(iniError); ok {
@@ -101,18 +395,123 @@ func failf(format string, args ...interface{}) {
 	panic(iniError{fmt.Errorf("ini: "+format, args...)})
 }
 
+// A SyntaxError is returned when the INI document is malformed.
+type SyntaxError struct {
+	Line    int    // Line of the problem, counting from 1.
+	Column  int    // Column of the problem, counting from 1.
+	Offset  int    // Offset of the problem, in characters from the start.
+	Context string // What was being parsed, as in "while scanning a quoted scalar", or "".
+	Message string // The problem, as in "found unexpected end of line".
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("ini: line %d: %s", e.Line, e.Message)
+}
+
+// A ParseError is returned by a tolerant Decoder or File when the INI
+// document has syntax errors. It holds every one of them, in order. When
+// this error is returned, the rest of the document is still decoded or
+// loaded.
+type ParseError struct {
+	Errors []*SyntaxError
+}
+
+func (e *ParseError) Error() string {
+	lines := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		lines[i] = strings.TrimPrefix(err.Error(), "ini: ")
+	}
+	return fmt.Sprintf("ini: syntax errors:\n  %s", strings.Join(lines, "\n  "))
+}
+
+func (e *ParseError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
+}
+
+// A DefinitionError is returned when keys or sections of the INI document
+// conflict with one another: a key or a section defined again with the
+// DuplicateError policy, sections inheriting each other, or a section
+// inheriting a section that is not defined.
+type DefinitionError struct {
+	Name    string // Name of the key or section.
+	Line    int    // Line of the definition, counting from 1.
+	Other   int    // Line of the definition it conflicts with, counting from 1, or 0.
+	Message string // The conflict, as in "key 'port' is already defined at line 3".
+}
+
+func (e *DefinitionError) Error() string {
+	return fmt.Sprintf("ini: line %d: %s", e.Line, e.Message)
+}
+
 // A TypeError is returned by Unmarshal when one or more fields in
 // the INI document cannot be properly decoded into the requested
 // types. When this error is returned, the value is still
 // unmarshaled partially.
+//
+// Errors holds the messages of Details, which locate each problem. As
+// TypeError unwraps to Details, errors.As finds the first DecodeError.
 type TypeError struct {
-	Errors []string
+	Errors  []string
+	Details []*DecodeError
+}
+
+func newTypeError(details []*DecodeError) *TypeError {
+	e := &TypeError{Details: details}
+	for _, detail := range details {
+		e.Errors = append(e.Errors, detail.Error())
+	}
+	return e
 }
 
 func (e *TypeError) Error() string {
 	return fmt.Sprintf("ini: unmarshal errors:\n  %s", strings.Join(e.Errors, "\n  "))
 }
 
+func (e *TypeError) Unwrap() []error {
+	errs := make([]error, len(e.Details))
+	for i, detail := range e.Details {
+		errs[i] = detail
+	}
+	return errs
+}
+
+// details returns Details, or a DecodeError for each of Errors if the
+// TypeError was made without them.
+func (e *TypeError) details() []*DecodeError {
+	if e.Details != nil {
+		return e.Details
+	}
+	details := make([]*DecodeError, len(e.Errors))
+	for i, msg := range e.Errors {
+		details[i] = &DecodeError{Message: msg}
+	}
+	return details
+}
+
+// A DecodeError is a value of the INI document that cannot be decoded
+// into the Go value meant to hold it, or a key or section matching no
+// struct field.
+type DecodeError struct {
+	Section string       // Name of the section holding the value.
+	KeyPath []string     // Keys leading to the value, or nil for a section.
+	Line    int          // Line of the value, counting from 1, or 0 if unknown.
+	Column  int          // Column of the value, counting from 1.
+	Value   string       // The value as written.
+	Type    reflect.Type // The Go type meant to hold the value, or nil.
+	Message string       // The problem, as in "cannot unmarshal ...".
+}
+
+func (e *DecodeError) Error() string {
+	if e.Line == 0 {
+		return e.Message
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
 // --------------------------------------------------------------------------
 // Maintain a mapping of keys to structure field indexes
 
@@ -129,10 +528,21 @@ type fieldInfo struct {
 	Key       string
 	Num       int
 	OmitEmpty bool
-	Flow      bool
+
+	// Flow writes a struct or map field as dotted keys of the current
+	// section instead of as a section of its own.
+	Flow bool
 
 	// Inline holds the field index if the field is part of an inlined struct.
 	Inline []int
+
+	// Default holds the value of the default tag of the field, which is
+	// decoded into the field when no key sets it, or nil if it has none.
+	Default *string
+
+	// Constraints holds the constraints among the flags of the tag, which
+	// the field is checked against once decoded, or nil if it has none.
+	Constraints *constraints
 }
 
 var structMap = make(map[reflect.Type]*structInfo)
@@ -156,6 +566,9 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 		}
 
 		info := fieldInfo{Num: i}
+		if def, ok := field.Tag.Lookup("default"); ok {
+			info.Default = &def
+		}
 
 		tag := field.Tag.Get("ini")
 		if tag == "" && strings.Index(string(field.Tag), ":") < 0 {
@@ -165,6 +578,59 @@ func getStructInfo(st reflect.Type) (*structInfo, error) {
 			continue
 		}
 
+		inline := false
+		fields := strings.Split(tag, ",")
+		if len(fields) > 1 {
+			for _, flag := range fields[1:] {
+				switch flag {
+				case "omitempty":
+					info.OmitEmpty = true
+				case "flow":
+					info.Flow = true
+				case "inline":
+					inline = true
+				default:
+					c := info.Constraints
+					if c == nil {
+						c = &constraints{}
+					}
+					ok, err := c.add(flag)
+					if err != nil {
+						return nil, errors.New(fmt.Sprintf("Invalid flag %q in tag %q of type %s: %v", flag, tag, st, err))
+					}
+					if !ok {
+						return nil, errors.New(fmt.Sprintf("Unsupported flag %q in tag %q of type %s", flag, tag, st))
+					}
+					info.Constraints = c
+				}
+			}
+			tag = fields[0]
+		}
+
+		if inline {
+			if field.Type.Kind() != reflect.Struct {
+				return nil, errors.New("Option ,inline needs a struct value field")
+			}
+			sinfo, err := getStructInfo(field.Type)
+			if err != nil {
+				return nil, err
+			}
+			for _, finfo := range sinfo.FieldsList {
+				if _, found := fieldsMap[finfo.Key]; found {
+					msg := "Duplicated key '" + finfo.Key + "' in struct " + st.String()
+					return nil, errors.New(msg)
+				}
+				if finfo.Inline == nil {
+					finfo.Inline = []int{i, finfo.Num}
+				} else {
+					finfo.Inline = append([]int{i}, finfo.Inline...)
+				}
+				fieldsMap[finfo.Key] = finfo
+				fieldsList = append(fieldsList, finfo)
+			}
+			continue
+		}
+
 		if tag != "" {
 			info.Key = tag
 		} else {
@@ -38,26 +38,26 @@ CaseInsensitive = true
 	var iniConf interface{}
 	err := ini.Unmarshal([]byte(iniContext), &iniConf)
 	c.Assert(err, IsNil)
-    value, ok := iniConf.(map[interface{}]interface{})
+	value, ok := iniConf.(map[interface{}]interface{})
 	c.Assert(ok, Equals, true, Commentf("value: %#v", iniConf))
-    section_value, ok :=  value["common"].(map[interface{}]interface{})
-    c.Assert(ok, Equals, true, Commentf("value: %#v", value["common"]))
-    string_1_value, ok :=  section_value["string_1"].(map[interface{}]interface{})
-    c.Assert(ok, Equals, true, Commentf("value: %#v", section_value["string_1"]))
-    c.Assert(string_1_value[1], DeepEquals, "testing")
-	string_3_value, ok :=  section_value["string_3"].(map[interface{}]interface{})
+	section_value, ok := value["common"].(map[interface{}]interface{})
+	c.Assert(ok, Equals, true, Commentf("value: %#v", value["common"]))
+	string_1_value, ok := section_value["string_1"].(map[interface{}]interface{})
+	c.Assert(ok, Equals, true, Commentf("value: %#v", section_value["string_1"]))
+	c.Assert(string_1_value[1], DeepEquals, "testing")
+	string_3_value, ok := section_value["string_3"].(map[interface{}]interface{})
 	c.Assert(ok, Equals, true, Commentf("value: %#v", section_value["string_3"]))
-	string_3_1_value, ok :=  string_3_value[1].(map[interface{}]interface{})
+	string_3_1_value, ok := string_3_value[1].(map[interface{}]interface{})
 	c.Assert(ok, Equals, true, Commentf("value: %#v", string_3_value["1"]))
-    c.Assert(string_3_1_value[1], DeepEquals, "testing_1")
+	c.Assert(string_3_1_value[1], DeepEquals, "testing_1")
 	c.Assert(string_3_1_value[2], DeepEquals, "testing_2")
 
-	section_value, ok =  value["Dev"].(map[interface{}]interface{})
+	section_value, ok = value["Dev"].(map[interface{}]interface{})
 	c.Assert(ok, Equals, true, Commentf("value: %#v", value["Dev"]))
-	string_value, ok :=  section_value["string"].(string)
+	string_value, ok := section_value["string"].(string)
 	c.Assert(ok, Equals, true, Commentf("value: %#v", section_value["string"]))
 	c.Assert(string_value, DeepEquals, "testing_long_long_long_long_long_long_long_long_long_long_long_long_long_long_long_long_long_long_long_long_long_long_long_long_long_long_long_long")
-	string_1_value, ok =  section_value["string_1"].(map[interface{}]interface{})
+	string_1_value, ok = section_value["string_1"].(map[interface{}]interface{})
 	c.Assert(ok, Equals, true, Commentf("value: %#v", section_value["string_1"]))
 	c.Assert(string_1_value[1], DeepEquals, "testing_dev")
 
@@ -71,12 +71,12 @@ const (
 
 	ini_SECTION_START_TOKEN   // A SECTION-START token.
 	ini_SECTION_INHERIT_TOKEN // A SECTION-INHERIT token.
-    ini_SECTION_ENTRY_TOKEN // A SECTION-ENTRY token.
+	ini_SECTION_ENTRY_TOKEN   // A SECTION-ENTRY token.
 
-	ini_KEY_TOKEN // An VALUE token.
-	ini_VALUE_TOKEN // An VALUE token.
-	ini_SCALAR_TOKEN        // A SCALAR token.
-	ini_MAP_TOKEN        // A MAP token.
+	ini_KEY_TOKEN    // An VALUE token.
+	ini_VALUE_TOKEN  // An VALUE token.
+	ini_SCALAR_TOKEN // A SCALAR token.
+	ini_MAP_TOKEN    // A MAP token.
 
 	ini_COMMENT_START_TOKEN // A COMMENT-START token.
 	ini_COMMENT_END_TOKEN   // A COMMENT-END token.
@@ -94,8 +94,8 @@ func (tt ini_token_type_t) String() string {
 		return "ini_SECTION_START_TOKEN"
 	case ini_SECTION_INHERIT_TOKEN:
 		return "ini_SECTION_INHERIT_TOKEN"
-    case ini_SECTION_ENTRY_TOKEN:
-        return "ini_SECTION_ENTRY_TOKEN"
+	case ini_SECTION_ENTRY_TOKEN:
+		return "ini_SECTION_ENTRY_TOKEN"
 	case ini_KEY_TOKEN:
 		return "ini_KEY_TOKEN"
 	case ini_VALUE_TOKEN:
@@ -124,6 +124,12 @@ type ini_token_t struct {
 
 	// The scalar style (for ini_SCALAR_TOKEN).
 	style ini_scalar_style_t
+
+	// The comment lines preceding the token (for ini_SCALAR_TOKEN and
+	// ini_DOCUMENT_END_TOKEN), and the comment that follows it on the
+	// same line (for ini_SCALAR_TOKEN and ini_SECTION_ENTRY_TOKEN).
+	head_comment []byte
+	line_comment []byte
 }
 
 // Events
@@ -138,10 +144,10 @@ const (
 	ini_DOCUMENT_START_EVENT  // A DOCUMENT-START event.
 	ini_DOCUMENT_END_EVENT    // A DOCUMENT-END event.
 	ini_SECTION_INHERIT_EVENT // A SECTION-INHERIT event.
-    ini_SECTION_ENTRY_EVENT   // A SECTION-ENTRY event.
+	ini_SECTION_ENTRY_EVENT   // A SECTION-ENTRY event.
 
-    ini_MAPPING_EVENT  // An MAPPING event.
-    ini_SCALAR_EVENT  // An SCALAR event.
+	ini_MAPPING_EVENT // An MAPPING event.
+	ini_SCALAR_EVENT  // An SCALAR event.
 	ini_COMMENT_EVENT // A COMMENT event.
 )
 
@@ -157,11 +163,19 @@ type ini_event_t struct {
 	// The node value.
 	value []byte
 
-    // The tag (for ini_SCALAR_EVENT).
-    tag []byte
+	// The tag (for ini_SCALAR_EVENT).
+	tag []byte
 
 	// The style (for ini_ELEMENT_START_EVENT).
 	style ini_style_t
+
+	// The comments attached to the event. Section names and keys may have
+	// head comments, section entries and values line comments, and the
+	// end of the document a foot comment. Comments are kept as written,
+	// with their '#' or ';' indicators.
+	head_comment []byte
+	line_comment []byte
+	foot_comment []byte
 }
 
 func (e *ini_event_t) event_type() string {
@@ -174,10 +188,10 @@ func (e *ini_event_t) event_type() string {
 		return "ini_DOCUMENT_END_EVENT"
 	case ini_SECTION_INHERIT_EVENT:
 		return "ini_SECTION_INHERIT_EVENT"
-    case ini_SECTION_ENTRY_EVENT:
-        return "ini_SECTION_ENTRY_EVENT"
-    case ini_MAPPING_EVENT:
-        return "ini_MAPPING_EVENT"
+	case ini_SECTION_ENTRY_EVENT:
+		return "ini_SECTION_ENTRY_EVENT"
+	case ini_MAPPING_EVENT:
+		return "ini_MAPPING_EVENT"
 	case ini_SCALAR_EVENT:
 		return "ini_SCALAR_EVENT"
 	case ini_COMMENT_EVENT:
@@ -197,11 +211,12 @@ const (
 	ini_INT_TAG    = "int"   // The tag 'int' for integer values.
 	ini_FLOAT_TAG  = "float" // The tag 'float' for float values.
 	ini_BINARY_TAG = "binary"
-    ini_MAP_TAG = "map"
-	
+	ini_MAP_TAG    = "map"
+	ini_SEQ_TAG    = "seq" // The tag 'seq' for the values of array keys.
+
 	ini_SECTION_TAG = "section"
 
-    ini_DEFAULT_SCALAR_TAG   = ini_STR_TAG // The default scalar tag is str
+	ini_DEFAULT_SCALAR_TAG = ini_STR_TAG // The default scalar tag is str
 )
 
 // The prototype of a read handler.
@@ -211,7 +226,9 @@ const (
 // The number of written bytes should be set to the size_read variable.
 //
 // [in,out]   data        A pointer to an application data specified by
-//                        ini_parser_set_input().
+//
+//	ini_parser_set_input().
+//
 // [out]      buffer      The buffer to write the data from the source.
 // [in]       size        The size of the buffer.
 // [out]      size_read   The actual number of bytes read from the source.
@@ -230,10 +247,10 @@ const (
 	ini_PARSE_DOCUMENT_END_STATE        // Expect DOCUMENT-START.
 	ini_PARSE_SECTION_FIRST_START_STATE // Expect SECTION-FIRST-ENTRY.
 	ini_PARSE_SECTION_START_STATE       // Expect SECTION-ENTRY.
-	ini_PARSE_SECTION_INHERIT_STATE 	// Expect SECTION-INHERIT.
+	ini_PARSE_SECTION_INHERIT_STATE     // Expect SECTION-INHERIT.
 	ini_PARSE_SECTION_ENTRY_STATE       // Expect SECTION-ENTRY.
-	ini_PARSE_SECTION_KEY_STATE   // Expect a KEY.
-    ini_PARSE_SECTION_VALUE_STATE   // Expect a VALUE.
+	ini_PARSE_SECTION_KEY_STATE         // Expect a KEY.
+	ini_PARSE_SECTION_VALUE_STATE       // Expect a VALUE.
 	ini_PARSE_COMMENT_START_STATE       // Expect COMMENT-START.
 	ini_PARSE_COMMENT_CONTENT_STATE     // Expect the content of a comment.
 	ini_PARSE_COMMENT_END_STATE         // Expect COMMENT-END.
@@ -290,9 +307,9 @@ type ini_parser_t struct {
 	// Reader stuff
 	read_handler ini_read_handler_t // Read handler.
 
-	input_file io.Reader // File input data.
-	input      []byte    // String input data.
-	input_pos  int
+	input_reader io.Reader // Reader input data.
+	input        []byte    // String input data.
+	input_pos    int
 
 	eof bool // EOF flag
 
@@ -307,7 +324,7 @@ type ini_parser_t struct {
 	offset int        // The offset of the current position (in bytes).
 	mark   ini_mark_t // The mark of the current position.
 
-    key_level int // The current key level.
+	key_level int // The current key level.
 
 	// Scanner stuff
 	document_start_produced bool // Have we started to scan the input stream?
@@ -318,10 +335,30 @@ type ini_parser_t struct {
 	tokens_parsed   int           // The number of tokens fetched from the queue.
 	token_available bool          // Does the tokens queue contain a token ready for dequeueing.
 
+	head_comment []byte // The comment lines waiting for the next section or key.
+
+	permissive_names bool // Accept any character but ']' in section names?
+
+	tolerant  bool            // Recover from syntax errors?
+	problems  []ini_problem_t // The syntax errors recovered from.
+	skip_keys bool            // Skip the keys of a broken section header?
+
+	continuation bool // Go on with values over continuation lines?
+	key_indent   int  // The indentation of the current key.
+
 	// Parser stuff
-	state  ini_parser_state_t   // The current parser state.
-	states []ini_parser_state_t // The parser states stack.
-	marks  []ini_mark_t         // The stack of marks.
+	state      ini_parser_state_t   // The current parser state.
+	states     []ini_parser_state_t // The parser states stack.
+	marks      []ini_mark_t         // The stack of marks.
+	token_mark ini_mark_t           // The start of the last token parsed.
+}
+
+// A syntax error the parser recovered from.
+type ini_problem_t struct {
+	problem      string     // Error description.
+	problem_mark ini_mark_t // The position of the problem.
+	context      string     // The error context.
+	context_mark ini_mark_t // The position of the context.
 }
 
 // Emitter Definitions
@@ -333,13 +370,14 @@ type ini_parser_t struct {
 // @a buffer to the output.
 //
 // @param[in,out]   data        A pointer to an application data specified by
-//                              ini_emitter_set_output().
+//
+//	ini_emitter_set_output().
+//
 // @param[in]       buffer      The buffer with bytes to be written.
 // @param[in]       size        The size of the buffer.
 //
 // @returns On success, the handler should return @c 1.  If the handler failed,
 // the returned value should be @c 0.
-//
 type ini_write_handler_t func(emitter *ini_emitter_t, buffer []byte) error
 
 type ini_emitter_state_t int
@@ -349,16 +387,13 @@ const (
 	// Expect DOCUMENT-START.
 	ini_EMIT_DOCUMENT_START_STATE ini_emitter_state_t = iota
 
-	ini_EMIT_DOCUMENT_END_STATE           // Expect DOCUMENT-END.
-	ini_EMIT_FIRST_SECTION_START_STATE    // Expect the first section
-	ini_EMIT_SECTION_START_STATE          // Expect the start of section.
-	ini_EMIT_SECTION_FIRST_NODE_KEY_STATE // Expect the start of section.
-	ini_EMIT_ELEMENT_KEY_STATE            // Expect the start of section.
-	ini_EMIT_ELEMENT_VALUE_STATE          // Expect the node.
-	ini_EMIT_SECTION_END_STATE            // Expect the end of section.
-	ini_EMIT_COMMENT_START_STATE          // Expect the start of section.
-	ini_EMIT_COMMENT_VALUE_STATE          // Expect the content of section.
-	ini_EMIT_COMMENT_END_STATE            // Expect the end of section.
+	ini_EMIT_DOCUMENT_END_STATE         // Expect nothing, the document is closed.
+	ini_EMIT_FIRST_SECTION_START_STATE  // Expect the first SECTION-ENTRY, a key or DOCUMENT-END.
+	ini_EMIT_SECTION_INHERIT_STATE      // Expect SECTION-INHERIT or the first key of a section.
+	ini_EMIT_SECTION_INHERIT_MORE_STATE // Expect another SECTION-INHERIT or the first key of a section.
+	ini_EMIT_ELEMENT_KEY_STATE          // Expect a key, SECTION-ENTRY or DOCUMENT-END.
+	ini_EMIT_MAPPING_KEY_STATE          // Expect the key following a MAPPING.
+	ini_EMIT_ELEMENT_VALUE_STATE        // Expect MAPPING or the value of a key.
 )
 
 // The emitter structure.
@@ -377,7 +412,7 @@ type ini_emitter_t struct {
 	write_handler ini_write_handler_t // Write handler.
 
 	output_buffer *[]byte   // String output data.
-	output_file   io.Writer // File output data.
+	output_writer io.Writer // Writer output data.
 
 	buffer     []byte // The working buffer.
 	buffer_pos int    // The current position of the buffer.
@@ -390,6 +425,8 @@ type ini_emitter_t struct {
 	unicode    bool        // Allow unescaped non-ASCII characters?
 	line_break ini_break_t // The preferred line break.
 
+	permissive_names bool // Allow any character but ']' in section names?
+
 	state  ini_emitter_state_t   // The current emitter state.
 	states []ini_emitter_state_t // The stack of states.
 
@@ -406,10 +443,13 @@ type ini_emitter_t struct {
 	whitespace bool // If the last character was a whitespace?
 	open_ended bool // If an explicit document end is required?
 
+	line_comment []byte // The comment to write at the end of the section header.
+
 	// Scalar analysis.
 	scalar_data struct {
 		value                 []byte             // The scalar value.
 		multiline             bool               // Does the scalar contain line breaks?
+		plain_allowed         bool               // Can the scalar be expressed in the plain style?
 		single_quoted_allowed bool               // Can the scalar be expressed in the single quoted style?
 		style                 ini_scalar_style_t // The output style.
 	}
@@ -0,0 +1,169 @@
+package ini
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxInterpolationDepth limits how deeply references may be nested
+// within one another.
+const maxInterpolationDepth = 32
+
+// An interpolator replaces the ${key} and ${section.key} references found
+// in the values of a document with the values they refer to.
+//
+// A ${key} reference is looked up in the section holding the value, which
+// includes the keys it inherits, and then in the default section. Keys of
+// dotted key groups are referred to by their full path, as in
+// ${server.port}. A ${section.key} reference names the section before
+// the key. A $${ stands for a literal ${. The ${key} references are only
+// resolved when the decoder interpolates, and the ${env:NAME} ones when
+// it has a lookupEnv function.
+//
+// Plain values are resolved again once interpolated, so "${port}" reads
+// back as an int if port does. A reference to an array key stands for its
+// last value.
+type interpolator struct {
+	doc        *node
+	resolved   map[*node]bool
+	stack      []reference
+	references bool
+	lookupEnv  func(string) (string, bool)
+	fold       bool
+}
+
+// reference is a value being interpolated, along with the name it was
+// referred to by.
+type reference struct {
+	name string
+	n    *node
+}
+
+// interpolate resolves the references of every value in doc, in place.
+func (d *decoder) interpolate(doc *node) {
+	in := &interpolator{doc: doc, resolved: make(map[*node]bool), references: d.interpolation, lookupEnv: d.lookupEnv, fold: d.fold}
+	for i := 0; i < len(doc.children); i += 2 {
+		in.walk(doc.children[i+1], "", doc.children[i+1])
+	}
+}
+
+func (in *interpolator) walk(section *node, prefix string, n *node) {
+	for i := 0; i < len(n.children); i += 2 {
+		name, value := prefix+n.children[i].value, n.children[i+1]
+		switch value.kind {
+		case mappingNode:
+			in.walk(section, name+".", value)
+		case scalarNode:
+			in.resolve(section, name, value)
+		case sequenceNode:
+			for _, item := range value.children {
+				in.resolve(section, name, item)
+			}
+		}
+	}
+}
+
+// resolve interpolates the value n of the section, known as name.
+func (in *interpolator) resolve(section *node, name string, n *node) {
+	if in.resolved[n] {
+		return
+	}
+	for i, ref := range in.stack {
+		if ref.n == n {
+			chain := make([]string, 0, len(in.stack)-i+1)
+			for _, ref := range append(in.stack[i:], reference{name, n}) {
+				chain = append(chain, fmt.Sprintf("${%s} (line %d)", ref.name, ref.n.line+1))
+			}
+			failf("line %d: circular reference %s", in.stack[len(in.stack)-1].n.line+1, strings.Join(chain, " -> "))
+		}
+	}
+	if len(in.stack) > maxInterpolationDepth {
+		failf("line %d: references nested deeper than %d levels", in.stack[len(in.stack)-1].n.line+1, maxInterpolationDepth)
+	}
+	in.stack = append(in.stack, reference{name, n})
+
+	var buf []byte
+	s := n.value
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		if i > 0 && s[i-1] == '$' {
+			// It is an escaped ${.
+			buf = append(buf, s[:i]...)
+			buf = append(buf, '{')
+			s = s[i+2:]
+			continue
+		}
+		j := strings.IndexByte(s[i+2:], '}')
+		if j < 0 {
+			break
+		}
+		buf = append(buf, s[:i]...)
+		buf = append(buf, in.lookup(section, n, s[i+2:i+2+j])...)
+		s = s[i+3+j:]
+	}
+	n.value = string(append(buf, s...))
+
+	in.stack = in.stack[:len(in.stack)-1]
+	in.resolved[n] = true
+}
+
+// lookup returns the interpolated value of the key called name, as
+// referred to from the value n of the section.
+func (in *interpolator) lookup(section, n *node, name string) string {
+	if strings.HasPrefix(name, "env:") {
+		return in.lookupEnvVar(n, name)
+	}
+	if !in.references {
+		return "${" + name + "}"
+	}
+	path := strings.Split(name, ".")
+	candidates := []*node{section}
+	if i := indexNode(in.doc, DEFAULT_SECTION, in.fold); i >= 0 {
+		candidates = append(candidates, in.doc.children[i+1])
+	}
+	for _, target := range candidates {
+		if _, value := lookupNode(target, path, in.fold); value != nil && lastValue(value).kind == scalarNode {
+			value = lastValue(value)
+			in.resolve(target, name, value)
+			return value.value
+		}
+	}
+	for i := 1; i < len(path); i++ {
+		j := indexNode(in.doc, strings.Join(path[:i], "."), in.fold)
+		if j < 0 {
+			continue
+		}
+		target := in.doc.children[j+1]
+		if _, value := lookupNode(target, path[i:], in.fold); value != nil && lastValue(value).kind == scalarNode {
+			value = lastValue(value)
+			in.resolve(target, name, value)
+			return value.value
+		}
+	}
+	failf("line %d: reference ${%s} is not defined", n.line+1, name)
+	panic("unreachable")
+}
+
+// lookupEnvVar returns the value of the ${env:NAME} or
+// ${env:NAME:-default} reference called name.
+func (in *interpolator) lookupEnvVar(n *node, name string) string {
+	if in.lookupEnv == nil {
+		return "${" + name + "}"
+	}
+	env, def := name[len("env:"):], ""
+	i := strings.Index(env, ":-")
+	if i >= 0 {
+		env, def = env[:i], env[i+2:]
+	}
+	value, ok := in.lookupEnv(env)
+	switch {
+	case i >= 0 && value == "":
+		return def
+	case !ok:
+		failf("line %d: environment variable %s is not set", n.line+1, env)
+	}
+	return value
+}
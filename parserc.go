@@ -19,6 +19,7 @@ func peek_token(parser *ini_parser_t) *ini_token_t {
 func skip_token(parser *ini_parser_t) {
 	parser.token_available = false
 	parser.tokens_parsed++
+	parser.token_mark = parser.tokens[parser.tokens_head].start_mark
 	parser.document_end_produced = parser.tokens[parser.tokens_head].typ == ini_DOCUMENT_END_TOKEN
 	parser.tokens_head++
 }
@@ -28,17 +29,154 @@ func ini_parser_parse(parser *ini_parser_t, event *ini_event_t) bool {
 	// Erase the event object.
 	*event = ini_event_t{}
 
-	// No events after the end of the stream or error.
-	if parser.document_end_produced || parser.error != ini_NO_ERROR || parser.state == ini_PARSE_DOCUMENT_END_STATE {
+	// No events after the end of the stream.
+	if parser.document_end_produced || parser.state == ini_PARSE_DOCUMENT_END_STATE {
 		return true
 	}
 
-	// Generate the next event.
-	return ini_parser_state_machine(parser, event)
+	// No events after an error either, unless the parser recovers from it.
+	if parser.error != ini_NO_ERROR {
+		if !ini_parser_recover(parser, event) {
+			return true
+		}
+		if event.typ != ini_NO_EVENT {
+			return true
+		}
+	}
+
+	// Generate the next event, leaving out the keys following a broken
+	// section header.
+	for {
+		state := parser.state
+		if !ini_parser_state_machine(parser, event) {
+			if !ini_parser_recover(parser, event) {
+				return false
+			}
+			if event.typ == ini_NO_EVENT {
+				continue
+			}
+		}
+		if !parser.skip_keys || state != ini_PARSE_SECTION_KEY_STATE && state != ini_PARSE_SECTION_VALUE_STATE {
+			return true
+		}
+		if event.typ != ini_SCALAR_EVENT && event.typ != ini_MAPPING_EVENT {
+			// The section ends.
+			parser.skip_keys = false
+			return true
+		}
+		*event = ini_event_t{}
+	}
+}
+
+// Recover from a scanner or parser error if the parser is tolerant.
+//
+// The error is kept in the problems of the parser, and the rest of the
+// line it was found on is skipped: the line of the token the parser
+// stopped at when it expected a key or a section, or else the line of the
+// key or section header it was in the middle of. A broken entry is
+// dropped whole, key included, as the parser checks each entry for its
+// value before producing the key. For a section header the events
+// completing it are produced: the default inherited section and the
+// section entry. The keys following a broken section header are parsed,
+// but left out of the events up to the next section header, rather than
+// being left to another section.
+//
+// Return false if the parser is not tolerant or the error is no syntax
+// error.
+func ini_parser_recover(parser *ini_parser_t, event *ini_event_t) bool {
+	if !parser.tolerant || parser.error != ini_SCANNER_ERROR && parser.error != ini_PARSER_ERROR {
+		return false
+	}
+	parser.problems = append(parser.problems, ini_problem_t{
+		problem:      parser.problem,
+		problem_mark: parser.problem_mark,
+		context:      parser.context,
+		context_mark: parser.context_mark,
+	})
+	mark := parser.problem_mark
+	scanner_error := parser.error == ini_SCANNER_ERROR
+	parser.error = ini_NO_ERROR
+	parser.problem = ""
+	parser.problem_mark = ini_mark_t{}
+	parser.context = ""
+	parser.context_mark = ini_mark_t{}
+
+	line := mark.line
+	switch parser.state {
+	case ini_PARSE_SECTION_INHERIT_STATE, ini_PARSE_SECTION_ENTRY_STATE, ini_PARSE_SECTION_VALUE_STATE:
+		line = parser.token_mark.line
+	}
+
+	// Is the error in a section header, either one being parsed or one
+	// the scanner broke off?
+	if parser.state == ini_PARSE_SECTION_INHERIT_STATE || parser.state == ini_PARSE_SECTION_ENTRY_STATE {
+		parser.skip_keys = true
+	}
+	for i := parser.tokens_head; i < len(parser.tokens); i++ {
+		if parser.tokens[i].typ == ini_SECTION_START_TOKEN && parser.tokens[i].start_mark.line == line {
+			parser.skip_keys = true
+		}
+	}
+
+	// Drop the tokens of the line. Those of a scanner error were all
+	// scanned along with the broken one.
+	if scanner_error {
+		parser.tokens_head = len(parser.tokens)
+	}
+	for parser.tokens_head < len(parser.tokens) {
+		token := &parser.tokens[parser.tokens_head]
+		if token.start_mark.line > line || token.typ == ini_DOCUMENT_END_TOKEN {
+			break
+		}
+		parser.tokens_head++
+	}
+	parser.token_available = false
+
+	// Skip the rest of the line, unless the scanner is past it already.
+	if scanner_error || parser.tokens_head == len(parser.tokens) && parser.mark.line <= line {
+		for {
+			if parser.unread < 2 && !ini_parser_update_buffer(parser, 2) {
+				return false
+			}
+			if is_breakz(parser.buffer, parser.buffer_pos) {
+				break
+			}
+			skip(parser)
+		}
+		skip_line(parser)
+	}
+
+	switch parser.state {
+	case ini_PARSE_SECTION_VALUE_STATE:
+		parser.state = ini_PARSE_SECTION_KEY_STATE
+		return ini_parser_process_empty_scalar(parser, event, mark)
+	case ini_PARSE_SECTION_INHERIT_STATE:
+		parser.state = ini_PARSE_SECTION_ENTRY_STATE
+		*event = ini_event_t{
+			typ:        ini_SECTION_INHERIT_EVENT,
+			start_mark: mark,
+			end_mark:   mark,
+			value:      []byte(DEFAULT_SECTION),
+			tag:        []byte(ini_STR_TAG),
+		}
+	case ini_PARSE_SECTION_ENTRY_STATE:
+		parser.state = ini_PARSE_SECTION_KEY_STATE
+		*event = ini_event_t{
+			typ:        ini_SECTION_ENTRY_EVENT,
+			start_mark: mark,
+			end_mark:   mark,
+			tag:        []byte(ini_SECTION_TAG),
+		}
+	}
+	return true
 }
 
 // Set parser error.
 func ini_parser_set_parser_error(parser *ini_parser_t, problem string, problem_mark ini_mark_t) bool {
+	if parser.error != ini_NO_ERROR {
+		// Keep the reader or scanner error that left no token to parse.
+		return false
+	}
 	parser.error = ini_PARSER_ERROR
 	parser.problem = problem
 	parser.problem_mark = problem_mark
@@ -108,9 +246,10 @@ func ini_parser_parse_section_start(parser *ini_parser_t, event *ini_event_t, fi
 			parser.state = parser.states[len(parser.states)-1]
 			parser.states = parser.states[:len(parser.states)-1]
 			*event = ini_event_t{
-				typ:        ini_DOCUMENT_END_EVENT,
-				start_mark: token.start_mark,
-				end_mark:   token.end_mark,
+				typ:          ini_DOCUMENT_END_EVENT,
+				start_mark:   token.start_mark,
+				end_mark:     token.end_mark,
+				foot_comment: token.head_comment,
 			}
 		} else {
 			if first && token.typ == ini_KEY_TOKEN {
@@ -130,11 +269,12 @@ func ini_parser_parse_section_start(parser *ini_parser_t, event *ini_event_t, fi
 						skip_token(parser)
 						parser.state = ini_PARSE_SECTION_INHERIT_STATE
 						*event = ini_event_t{
-							typ:        ini_SCALAR_EVENT,
-							start_mark: token.start_mark,
-							end_mark:   token.end_mark,
-							value:      []byte(token.value),
-							tag:        []byte(ini_STR_TAG),
+							typ:          ini_SCALAR_EVENT,
+							start_mark:   token.start_mark,
+							end_mark:     token.end_mark,
+							value:        []byte(token.value),
+							tag:          []byte(ini_STR_TAG),
+							head_comment: token.head_comment,
 						}
 					} else {
 						return ini_parser_set_parser_error(parser, "did not find expected <scalar>", token.start_mark)
@@ -170,6 +310,18 @@ func ini_parser_parse_section_inherit(parser *ini_parser_t, event *ini_event_t)
 					end_mark = token.end_mark
 					section_key = token.value
 					skip_token(parser)
+					// Another section may be inherited after a ','.
+					token = peek_token(parser)
+					if token != nil && token.typ == ini_SECTION_INHERIT_TOKEN {
+						*event = ini_event_t{
+							typ:        ini_SECTION_INHERIT_EVENT,
+							start_mark: start_mark,
+							end_mark:   end_mark,
+							value:      section_key,
+							tag:        []byte(ini_STR_TAG),
+						}
+						return true
+					}
 				} else {
 					return ini_parser_set_parser_error(parser, "did not find expected <scalar>", token.start_mark)
 				}
@@ -196,10 +348,11 @@ func ini_parser_parse_section_entry(parser *ini_parser_t, event *ini_event_t) bo
 	if token != nil && token.typ == ini_SECTION_ENTRY_TOKEN {
 		skip_token(parser)
 		*event = ini_event_t{
-			typ:        ini_SECTION_ENTRY_EVENT,
-			start_mark: token.start_mark,
-			end_mark:   token.end_mark,
-			tag:        []byte(ini_SECTION_TAG),
+			typ:          ini_SECTION_ENTRY_EVENT,
+			start_mark:   token.start_mark,
+			end_mark:     token.end_mark,
+			tag:          []byte(ini_SECTION_TAG),
+			line_comment: token.line_comment,
 		}
 	} else {
 		*event = ini_event_t{
@@ -215,30 +368,33 @@ func ini_parser_parse_section_entry(parser *ini_parser_t, event *ini_event_t) bo
 
 // Parse the productions:
 // properties           ::= (KEY1.KEY2 = VALUE)
-//
 func ini_parser_parse_key(parser *ini_parser_t, event *ini_event_t) bool {
 	token := peek_token(parser)
 	if token != nil {
 		if token.typ == ini_KEY_TOKEN {
+			if !ini_parser_check_entry(parser) {
+				return false
+			}
 			skip_token(parser)
 			token := peek_token(parser)
 			if token != nil {
-                if token.typ == ini_SCALAR_TOKEN {
-                    skip_token(parser)
-                    parser.state = ini_PARSE_SECTION_VALUE_STATE
-                    *event = ini_event_t{
-                        typ:        ini_SCALAR_EVENT,
-                        start_mark: token.start_mark,
-                        end_mark:   token.end_mark,
-                        value:      token.value,
-                        style:      ini_style_t(token.style),
-                    }
-                } else {
-                    return ini_parser_set_parser_error(parser, "did not find expected <scalar>", token.start_mark)
-                }
-            } else {
-                return ini_parser_set_parser_error(parser, "did not find expected <scalar>", parser.mark)
-            }
+				if token.typ == ini_SCALAR_TOKEN {
+					skip_token(parser)
+					parser.state = ini_PARSE_SECTION_VALUE_STATE
+					*event = ini_event_t{
+						typ:          ini_SCALAR_EVENT,
+						start_mark:   token.start_mark,
+						end_mark:     token.end_mark,
+						value:        token.value,
+						style:        ini_style_t(token.style),
+						head_comment: token.head_comment,
+					}
+				} else {
+					return ini_parser_set_parser_error(parser, "did not find expected <scalar>", token.start_mark)
+				}
+			} else {
+				return ini_parser_set_parser_error(parser, "did not find expected <scalar>", parser.mark)
+			}
 		} else {
 			if token.typ != ini_SECTION_START_TOKEN && token.typ != ini_DOCUMENT_END_TOKEN {
 				return ini_parser_set_parser_error(parser, "did not find expected <key> or <section-start>", token.start_mark)
@@ -257,6 +413,32 @@ func ini_parser_parse_key(parser *ini_parser_t, event *ini_event_t) bool {
 	return true
 }
 
+// Check that the key at the head of the queue is followed by a value,
+// fetching the tokens of the whole entry first, so that a broken entry
+// produces no event at all. The problem is reported right after the key,
+// where the value was expected.
+func ini_parser_check_entry(parser *ini_parser_t) bool {
+	// The tokens of a key, KEY SCALAR (MAP KEY SCALAR)*, are all queued
+	// at once, and so are those of its value.
+	k := 0
+	for {
+		for parser.tokens_head+k+2 >= len(parser.tokens) {
+			if !ini_parser_fetch_next_token(parser) {
+				return false
+			}
+		}
+		if parser.tokens[parser.tokens_head+k+2].typ != ini_MAP_TOKEN {
+			break
+		}
+		k += 3
+	}
+	if parser.tokens[parser.tokens_head+k+2].typ != ini_VALUE_TOKEN {
+		return ini_parser_set_parser_error(parser, "did not find expected <value> or <map>",
+			parser.tokens[parser.tokens_head+k+1].end_mark)
+	}
+	return true
+}
+
 func ini_parser_parse_value(parser *ini_parser_t, event *ini_event_t) bool {
 	token := peek_token(parser)
 	if token != nil {
@@ -275,11 +457,12 @@ func ini_parser_parse_value(parser *ini_parser_t, event *ini_event_t) bool {
 				skip_token(parser)
 				parser.state = ini_PARSE_SECTION_KEY_STATE
 				*event = ini_event_t{
-					typ:        ini_SCALAR_EVENT,
-					start_mark: token.start_mark,
-					end_mark:   token.end_mark,
-					value:      token.value,
-					style:      ini_style_t(token.style),
+					typ:          ini_SCALAR_EVENT,
+					start_mark:   token.start_mark,
+					end_mark:     token.end_mark,
+					value:        token.value,
+					style:        ini_style_t(token.style),
+					line_comment: token.line_comment,
 				}
 			} else {
 				return ini_parser_set_parser_error(parser, "did not find expected <scalar>", token.start_mark)
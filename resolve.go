@@ -24,9 +24,9 @@ func init() {
 	for _, c := range "0123456789" {
 		t[int(c)] = 'D' // Digit
 	}
-    for _, c := range "yYnNtTfFoO~" {
-        t[int(c)] = 'M' // In map
-    }
+	for _, c := range "yYnNtTfFoO~" {
+		t[int(c)] = 'M' // In map
+	}
 	t[int('.')] = '.' // Float (potentially in map)
 
 	var resolveMapList = []struct {
@@ -3,6 +3,7 @@ package ini
 import (
 	"bytes"
 	"fmt"
+	"unicode/utf8"
 )
 
 // Introduction
@@ -346,9 +347,10 @@ func ini_parser_fetch_document_end(parser *ini_parser_t) bool {
 	}
 	// Create the DOCUMENT-END token and append it to the queue.
 	token := ini_token_t{
-		typ:        ini_DOCUMENT_END_TOKEN,
-		start_mark: parser.mark,
-		end_mark:   parser.mark,
+		typ:          ini_DOCUMENT_END_TOKEN,
+		start_mark:   parser.mark,
+		end_mark:     parser.mark,
+		head_comment: ini_parser_take_head_comment(parser),
 	}
 	ini_insert_token(parser, -1, &token)
 	return true
@@ -456,31 +458,44 @@ func ini_parser_fetch_section_start(parser *ini_parser_t) bool {
 	if !ini_parser_fetch_section_key(parser, &scalar_token) {
 		return false
 	}
+	scalar_token.head_comment = ini_parser_take_head_comment(parser)
 	ini_insert_token(parser, -1, &scalar_token)
 
 	return true
 }
 
+// Produce the SECTION-INHERIT and SCALAR tokens of each inherited section,
+// the first one following the ':' indicator and the others a ','.
 func ini_parser_fetch_section_inherit(parser *ini_parser_t) bool {
-	// Consume the token.
-	start_mark := parser.mark
-	skip(parser)
-	end_mark := parser.mark
-	section_inherit_token := ini_token_t{
-		typ:        ini_SECTION_INHERIT_TOKEN,
-		start_mark: start_mark,
-		end_mark:   end_mark,
-		value:      []byte(":"),
-	}
-	ini_insert_token(parser, -1, &section_inherit_token)
-	// Produce the SCALAR(...,plain) token.
-	// Create the SCALAR token and append it to the queue.
-	var scalar_token ini_token_t
-	if !ini_parser_fetch_section_key(parser, &scalar_token) {
-		return false
+	for {
+		// Consume the token.
+		start_mark := parser.mark
+		value := []byte{parser.buffer[parser.buffer_pos]}
+		skip(parser)
+		end_mark := parser.mark
+		section_inherit_token := ini_token_t{
+			typ:        ini_SECTION_INHERIT_TOKEN,
+			start_mark: start_mark,
+			end_mark:   end_mark,
+			value:      value,
+		}
+		ini_insert_token(parser, -1, &section_inherit_token)
+		// Produce the SCALAR(...,plain) token.
+		// Create the SCALAR token and append it to the queue.
+		var scalar_token ini_token_t
+		if !ini_parser_fetch_section_key(parser, &scalar_token) {
+			return false
+		}
+		if len(scalar_token.value) == 0 {
+			return ini_parser_set_scanner_error(parser,
+				"while scanning for the section key", parser.mark,
+				"found an empty inherited section name")
+		}
+		ini_insert_token(parser, -1, &scalar_token)
+		if parser.buffer[parser.buffer_pos] != ',' {
+			return true
+		}
 	}
-	ini_insert_token(parser, -1, &scalar_token)
-	return true
 }
 
 func ini_parser_fetch_section_entry(parser *ini_parser_t) bool {
@@ -492,34 +507,49 @@ func ini_parser_fetch_section_entry(parser *ini_parser_t) bool {
 	start_mark := parser.mark
 	skip(parser)
 	end_mark := parser.mark
-	if !is_break(parser.buffer, parser.buffer_pos) {
+	var line_comment []byte
+	if !ini_parser_scan_line_comment(parser, &line_comment) {
+		return false
+	}
+	if !is_breakz(parser.buffer, parser.buffer_pos) {
 		return ini_parser_set_scanner_error(parser,
 			"while scanning for the section entry", parser.mark,
 			"must have a line break before the first section key")
 	}
 	token := ini_token_t{
-		typ:        ini_SECTION_ENTRY_TOKEN,
-		start_mark: start_mark,
-		end_mark:   end_mark,
-		value:      []byte("]"),
+		typ:          ini_SECTION_ENTRY_TOKEN,
+		start_mark:   start_mark,
+		end_mark:     end_mark,
+		value:        []byte("]"),
+		line_comment: line_comment,
 	}
 	ini_insert_token(parser, -1, &token)
 	return true
 }
 
+// Scan a section key, that is the name of a section or of an inherited
+// section.
+//
+// A section key is made of segments of alphanumerical characters, '_' and
+// '-', separated by '.' to name nested sections. With permissive names
+// any character but ']' is allowed instead, leaving no room for the ':'
+// and ',' inheritance indicators. Either way, the key may end with a git
+// style quoted subsection, as in [remote "origin"], which is kept in that
+// form with only '"' and '\\' escaped.
 func ini_parser_fetch_section_key(parser *ini_parser_t, token *ini_token_t) bool {
-    // Eat whitespaces.
-    if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
-        return false
-    }
-    for is_blank(parser.buffer, parser.buffer_pos) {
-        skip(parser)
-        if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
-            return false
-        }
-    }
+	// Eat whitespaces.
+	if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+		return false
+	}
+	for is_blank(parser.buffer, parser.buffer_pos) {
+		skip(parser)
+		if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+			return false
+		}
+	}
 	start_mark := parser.mark
 	var s []byte
+	subsection := false
 	// Consume the content of the plain scalar.
 	for {
 		if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
@@ -528,20 +558,65 @@ func ini_parser_fetch_section_key(parser *ini_parser_t, token *ini_token_t) bool
 		if is_break(parser.buffer, parser.buffer_pos) {
 			break
 		}
-		if parser.buffer[parser.buffer_pos] == ':' || parser.buffer[parser.buffer_pos] == '[' || parser.buffer[parser.buffer_pos] == ']' {
+		c := parser.buffer[parser.buffer_pos]
+		if c == ']' || !parser.permissive_names && (c == ':' || c == ',' || c == '[') {
 			break
 		}
-		if !is_alpha(parser.buffer, parser.buffer_pos) {
-			return ini_parser_set_scanner_error(parser,
-				"while scanning for the section key", parser.mark,
-				"found character("+string([]byte{parser.buffer[parser.buffer_pos]})+") that cannot start for any section key")
+		if c == '"' && len(bytes.TrimRight(s, " \t")) > 0 && is_blank(s, len(s)-1) {
+			// It is a quoted subsection.
+			if !ini_parser_scan_subsection(parser, &s) {
+				return false
+			}
+			subsection = true
+			break
+		}
+		if !parser.permissive_names {
+			if is_blank(parser.buffer, parser.buffer_pos) {
+				// Blanks may only separate the key from a quoted
+				// subsection or from the next indicator.
+				for is_blank(parser.buffer, parser.buffer_pos) {
+					skip(parser)
+					if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+						return false
+					}
+				}
+				if parser.buffer[parser.buffer_pos] == '"' && len(s) > 0 {
+					s = append(s, ' ')
+					continue
+				}
+				if c := parser.buffer[parser.buffer_pos]; c != ':' && c != ',' && c != ']' && !is_breakz(parser.buffer, parser.buffer_pos) {
+					return ini_parser_set_scanner_error(parser,
+						"while scanning for the section key", parser.mark,
+						"found a blank inside the section key")
+				}
+				break
+			}
+			if !is_alpha(parser.buffer, parser.buffer_pos) && c != '.' {
+				return ini_parser_set_scanner_error(parser,
+					"while scanning for the section key", parser.mark,
+					"found character("+string([]byte{c})+") that cannot start for any section key")
+			}
 		}
 		// Copy the character.
 		s = read(parser, s)
 	}
 	end_mark := parser.mark
 	// Trim blank characters.
-	s = bytes.Trim(s, " ")
+	s = bytes.Trim(s, " \t")
+	// A dotted section key names a section nested in another one.
+	base := s
+	if subsection {
+		base = s[:bytes.Index(s, []byte(` "`))]
+	}
+	if !parser.permissive_names && bytes.IndexByte(base, '.') >= 0 {
+		for _, segment := range bytes.Split(base, []byte{'.'}) {
+			if len(segment) == 0 {
+				return ini_parser_set_scanner_error(parser,
+					"while scanning for the section key", start_mark,
+					"found an empty segment in the dotted section key")
+			}
+		}
+	}
 	// Create a token.
 	*token = ini_token_t{
 		typ:        ini_SCALAR_TOKEN,
@@ -553,19 +628,80 @@ func ini_parser_fetch_section_key(parser *ini_parser_t, token *ini_token_t) bool
 	return true
 }
 
+// Scan the quoted subsection ending a section key, appending it to s.
+//
+// As in git, '\\' escapes the next character. Nothing but blanks may come
+// between the subsection and the next indicator.
+func ini_parser_scan_subsection(parser *ini_parser_t, s *[]byte) bool {
+	start_mark := parser.mark
+	*s = append(bytes.TrimRight(*s, " \t"), ' ', '"')
+
+	// Eat the left quote.
+	skip(parser)
+	for {
+		if parser.unread < 2 && !ini_parser_update_buffer(parser, 2) {
+			return false
+		}
+		if is_breakz(parser.buffer, parser.buffer_pos) {
+			return ini_parser_set_scanner_error(parser, "while scanning a quoted subsection",
+				start_mark, "found unexpected end of line")
+		}
+		c := parser.buffer[parser.buffer_pos]
+		if c == '"' {
+			break
+		}
+		if c == '\\' {
+			skip(parser)
+			if is_breakz(parser.buffer, parser.buffer_pos) {
+				return ini_parser_set_scanner_error(parser, "while scanning a quoted subsection",
+					start_mark, "found unexpected end of line")
+			}
+			c = parser.buffer[parser.buffer_pos]
+		}
+		if c == '"' || c == '\\' {
+			*s = append(*s, '\\')
+		}
+		*s = read(parser, *s)
+	}
+	*s = append(*s, '"')
+
+	// Eat the right quote.
+	skip(parser)
+	if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+		return false
+	}
+	for is_blank(parser.buffer, parser.buffer_pos) {
+		skip(parser)
+		if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+			return false
+		}
+	}
+	c := parser.buffer[parser.buffer_pos]
+	if c != ']' && (parser.permissive_names || c != ':' && c != ',') && !is_breakz(parser.buffer, parser.buffer_pos) {
+		return ini_parser_set_scanner_error(parser, "while scanning a quoted subsection",
+			start_mark, "did not find expected ']' after the subsection")
+	}
+	return true
+}
+
 func ini_parser_fetch_key(parser *ini_parser_t) bool {
-    // Eat whitespaces.
-    if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
-        return false
-    }
-    for is_blank(parser.buffer, parser.buffer_pos) {
-        skip(parser)
-        if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
-            return false
-        }
-    }
+	// Eat whitespaces.
+	if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+		return false
+	}
+	for is_blank(parser.buffer, parser.buffer_pos) {
+		skip(parser)
+		if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+			return false
+		}
+	}
+	parser.key_indent = parser.mark.column
+
 	// Produce the SCALAR(...,plain) token.
 	var key_token ini_token_t
+	if parser.unread < 2 && !ini_parser_update_buffer(parser, 2) {
+		return false
+	}
 	if parser.buffer[parser.buffer_pos] == '\'' {
 		// key must start with alpha([0-9a-zA-Z_-])
 		if !is_alpha(parser.buffer, parser.buffer_pos+1) && parser.buffer[parser.buffer_pos+1] != '~' {
@@ -581,13 +717,14 @@ func ini_parser_fetch_key(parser *ini_parser_t) bool {
 			return false
 		}
 	} else {
-		if !ini_parser_scan_plain_scalar(parser, &key_token) {
+		if !ini_parser_scan_plain_key(parser, &key_token) {
 			return false
 		}
 	}
 	keys := bytes.Split(key_token.value, []byte("."))
 	key_len := len(keys)
 	key_start_mark := key_token.start_mark
+	key_style := key_token.style
 	for i := 0; i < key_len; i++ {
 		if len(keys[i]) == 0 {
 			return ini_parser_set_scanner_error(parser,
@@ -602,27 +739,40 @@ func ini_parser_fetch_key(parser *ini_parser_t) bool {
 		}
 		ini_insert_token(parser, -1, &key_token)
 		key_end_mark := key_start_mark
-		key_end_mark.index = key_start_mark.index + len(keys[i])
+		if key_len == 1 && key_style != ini_PLAIN_SCALAR_STYLE {
+			key_end_mark = key_token.end_mark
+		} else {
+			n := utf8.RuneCount(keys[i])
+			key_end_mark.index += n
+			key_end_mark.column += n
+		}
 		scalar_token := ini_token_t{
 			typ:        ini_SCALAR_TOKEN,
 			start_mark: key_start_mark,
 			end_mark:   key_end_mark,
 			value:      keys[i],
-			style:      ini_PLAIN_SCALAR_STYLE,
+			style:      key_style,
+		}
+		if i == key_len-1 {
+			// The comments belong to the key itself rather than to the
+			// map keys leading to it, which other keys may share.
+			scalar_token.head_comment = ini_parser_take_head_comment(parser)
 		}
 		ini_insert_token(parser, -1, &scalar_token)
 		if i < key_len-1 {
 			// map
-			key_start_mark = key_end_mark
-			key_end_mark.index = key_start_mark.index + 1
+			map_end_mark := key_end_mark
+			map_end_mark.index++
+			map_end_mark.column++
 			map_token := ini_token_t{
 				typ:        ini_MAP_TOKEN,
-				start_mark: key_start_mark,
-				end_mark:   key_end_mark,
+				start_mark: key_end_mark,
+				end_mark:   map_end_mark,
 				value:      []byte("."),
 				style:      ini_PLAIN_SCALAR_STYLE,
 			}
 			ini_insert_token(parser, -1, &map_token)
+			key_start_mark = map_end_mark
 		}
 	}
 	return true
@@ -645,28 +795,34 @@ func ini_parser_fetch_value(parser *ini_parser_t) bool {
 		value:      []byte("="),
 	}
 	ini_insert_token(parser, -1, &token)
-    // Eat whitespaces.
-    if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
-        return false
-    }
-    for is_blank(parser.buffer, parser.buffer_pos) {
-        skip(parser)
-        if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
-            return false
-        }
-    }
+	// Eat whitespaces.
+	if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+		return false
+	}
+	for is_blank(parser.buffer, parser.buffer_pos) {
+		skip(parser)
+		if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+			return false
+		}
+	}
 	// Produce the SCALAR(...,plain) token.
 	if parser.buffer[parser.buffer_pos] == '\'' {
 		// Is it a single-quoted scalar?
 		if !ini_parser_scan_scalar(parser, &token, true) {
 			return false
 		}
+		if !ini_parser_scan_line_comment(parser, &token.line_comment) {
+			return false
+		}
 		ini_insert_token(parser, -1, &token)
 	} else if parser.buffer[parser.buffer_pos] == '"' {
 		// Is it a double-quoted scalar?
 		if !ini_parser_scan_scalar(parser, &token, false) {
 			return false
 		}
+		if !ini_parser_scan_line_comment(parser, &token.line_comment) {
+			return false
+		}
 		ini_insert_token(parser, -1, &token)
 	} else {
 		// Is it a plain scalar?
@@ -678,9 +834,18 @@ func ini_parser_fetch_value(parser *ini_parser_t) bool {
 	return true
 }
 
-// Scan a node value.
+// Scan a quoted scalar.
+//
+// Inside single quotes a quote is escaped by doubling it, inside double
+// quotes the usual backslash escape sequences are recognized. Double
+// quotes may span several lines; a '\\' ending a line joins it to the next
+// one, leaving out the indentation of the latter.
 func ini_parser_scan_scalar(parser *ini_parser_t, token *ini_token_t, single bool) bool {
 	start_mark := parser.mark
+
+	// Eat the left quote.
+	skip(parser)
+
 	var s []byte
 	// Consume the content of the quoted scalar.
 	for {
@@ -688,179 +853,167 @@ func ini_parser_scan_scalar(parser *ini_parser_t, token *ini_token_t, single boo
 			return false
 		}
 		if is_z(parser.buffer, parser.buffer_pos) {
-			break
+			return ini_parser_set_scanner_error(parser, "while scanning a quoted scalar",
+				start_mark, "found unexpected end of stream")
+		}
+		if is_break(parser.buffer, parser.buffer_pos) {
+			if single {
+				return ini_parser_set_scanner_error(parser, "while scanning a quoted scalar",
+					start_mark, "found unexpected line break")
+			}
+			if parser.unread < 2 && !ini_parser_update_buffer(parser, 2) {
+				return false
+			}
+			s = read_line(parser, s)
+			continue
 		}
 		if single {
 			if parser.buffer[parser.buffer_pos] == '\'' && parser.buffer[parser.buffer_pos+1] == '\'' {
-				// Is is an escaped single quote.
+				// It is an escaped single quote.
+				s = append(s, '\'')
 				skip(parser)
 				skip(parser)
-			} else if parser.buffer[parser.buffer_pos] == '\'' {
-				// It is a left single quote.
-				skip(parser)
-				// It is a non-escaped non-blank character.
-				for parser.buffer[parser.buffer_pos] != '\'' {
-					if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
-						return false
-					}
-					if is_breakz(parser.buffer, parser.buffer_pos) {
-						return false
-					}
-					s = read(parser, s)
-				}
+				continue
 			}
-			// Check if we are at the end of the scalar.
 			if parser.buffer[parser.buffer_pos] == '\'' {
-				skip(parser)
+				// It is the right single quote.
 				break
 			}
-		} else {
-			if parser.buffer[parser.buffer_pos] == '"' && parser.buffer[parser.buffer_pos+1] == '"' {
-				// Is is an escaped double quote.
-				skip(parser)
-				skip(parser)
-			} else if parser.buffer[parser.buffer_pos] == '"' {
-				// It is a left double quote.
-				skip(parser)
-				// It is a non-escaped non-blank character.
-				for parser.buffer[parser.buffer_pos] != '"' {
-					if parser.unread < 1 || !ini_parser_update_buffer(parser, 1) {
-						return false
-					}
-					if is_breakz(parser.buffer, parser.buffer_pos) {
-						return false
-					}
-					s = read(parser, s)
-				}
-			} else if parser.buffer[parser.buffer_pos] == '\\' && is_break(parser.buffer, parser.buffer_pos+1) {
-				// It is an escaped line break.
-				if parser.unread < 3 && !ini_parser_update_buffer(parser, 3) {
-					return false
-				}
+			s = read(parser, s)
+			continue
+		}
+		if parser.buffer[parser.buffer_pos] == '"' {
+			// It is the right double quote.
+			break
+		}
+		if parser.buffer[parser.buffer_pos] != '\\' {
+			s = read(parser, s)
+			continue
+		}
+
+		// It is an escaped line break.
+		if parser.unread < 3 && !ini_parser_update_buffer(parser, 3) {
+			return false
+		}
+		if is_break(parser.buffer, parser.buffer_pos+1) {
+			skip(parser)
+			skip_line(parser)
+			if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+				return false
+			}
+			for is_blank(parser.buffer, parser.buffer_pos) {
 				skip(parser)
-				skip_line(parser)
-				break
-			} else if parser.buffer[parser.buffer_pos] == '\\' {
-				// It is an escape sequence.
-				code_length := 0
-				// Check the escape character.
-				switch parser.buffer[parser.buffer_pos+1] {
-				case '0':
-					s = append(s, 0)
-				case 'a':
-					s = append(s, '\x07')
-				case 'b':
-					s = append(s, '\x08')
-				case 't', '\t':
-					s = append(s, '\x09')
-				case 'n':
-					s = append(s, '\x0A')
-				case 'v':
-					s = append(s, '\x0B')
-				case 'f':
-					s = append(s, '\x0C')
-				case 'r':
-					s = append(s, '\x0D')
-				case 'e':
-					s = append(s, '\x1B')
-				case ' ':
-					s = append(s, '\x20')
-				case '"':
-					s = append(s, '"')
-				case '\'':
-					s = append(s, '\'')
-				case '\\':
-					s = append(s, '\\')
-				case 'N': // NEL (#x85)
-					s = append(s, '\xC2')
-					s = append(s, '\x85')
-				case '_': // #xA0
-					s = append(s, '\xC2')
-					s = append(s, '\xA0')
-				case 'L': // LS (#x2028)
-					s = append(s, '\xE2')
-					s = append(s, '\x80')
-					s = append(s, '\xA8')
-				case 'P': // PS (#x2029)
-					s = append(s, '\xE2')
-					s = append(s, '\x80')
-					s = append(s, '\xA9')
-				case 'x':
-					code_length = 2
-				case 'u':
-					code_length = 4
-				case 'U':
-					code_length = 8
-				default:
-					ini_parser_set_scanner_error(parser, "while parsing a quoted scalar",
-						start_mark, "found unknown escape character")
+				if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
 					return false
 				}
+			}
+			continue
+		}
 
-				skip(parser)
-				skip(parser)
-
-				// Consume an arbitrary escape code.
-				if code_length > 0 {
-					var value int
+		// It is an escape sequence.
+		code_length := 0
+		// Check the escape character.
+		switch parser.buffer[parser.buffer_pos+1] {
+		case '0':
+			s = append(s, 0)
+		case 'a':
+			s = append(s, '\x07')
+		case 'b':
+			s = append(s, '\x08')
+		case 't', '\t':
+			s = append(s, '\x09')
+		case 'n':
+			s = append(s, '\x0A')
+		case 'v':
+			s = append(s, '\x0B')
+		case 'f':
+			s = append(s, '\x0C')
+		case 'r':
+			s = append(s, '\x0D')
+		case 'e':
+			s = append(s, '\x1B')
+		case ' ':
+			s = append(s, '\x20')
+		case '"':
+			s = append(s, '"')
+		case '\'':
+			s = append(s, '\'')
+		case '\\':
+			s = append(s, '\\')
+		case 'N': // NEL (#x85)
+			s = append(s, '\xC2')
+			s = append(s, '\x85')
+		case '_': // #xA0
+			s = append(s, '\xC2')
+			s = append(s, '\xA0')
+		case 'L': // LS (#x2028)
+			s = append(s, '\xE2')
+			s = append(s, '\x80')
+			s = append(s, '\xA8')
+		case 'P': // PS (#x2029)
+			s = append(s, '\xE2')
+			s = append(s, '\x80')
+			s = append(s, '\xA9')
+		case 'x':
+			code_length = 2
+		case 'u':
+			code_length = 4
+		case 'U':
+			code_length = 8
+		default:
+			return ini_parser_set_scanner_error(parser, "while parsing a quoted scalar",
+				start_mark, "found unknown escape character")
+		}
 
-					// Scan the character value.
-					if parser.unread < code_length && !ini_parser_update_buffer(parser, code_length) {
-						return false
-					}
-					for k := 0; k < code_length; k++ {
-						if !is_hex(parser.buffer, parser.buffer_pos+k) {
-							ini_parser_set_scanner_error(parser, "while parsing a quoted scalar",
-								start_mark, "did not find expected hexdecimal number")
-							return false
-						}
-						value = (value << 4) + as_hex(parser.buffer, parser.buffer_pos+k)
-					}
+		skip(parser)
+		skip(parser)
 
-					// Check the value and write the character.
-					if (value >= 0xD800 && value <= 0xDFFF) || value > 0x10FFFF {
-						ini_parser_set_scanner_error(parser, "while parsing a quoted scalar",
-							start_mark, "found invalid Unicode character escape code")
-						return false
-					}
-					if value <= 0x7F {
-						s = append(s, byte(value))
-					} else if value <= 0x7FF {
-						s = append(s, byte(0xC0+(value>>6)))
-						s = append(s, byte(0x80+(value&0x3F)))
-					} else if value <= 0xFFFF {
-						s = append(s, byte(0xE0+(value>>12)))
-						s = append(s, byte(0x80+((value>>6)&0x3F)))
-						s = append(s, byte(0x80+(value&0x3F)))
-					} else {
-						s = append(s, byte(0xF0+(value>>18)))
-						s = append(s, byte(0x80+((value>>12)&0x3F)))
-						s = append(s, byte(0x80+((value>>6)&0x3F)))
-						s = append(s, byte(0x80+(value&0x3F)))
-					}
+		// Consume an arbitrary escape code.
+		if code_length > 0 {
+			var value int
 
-					// Advance the pointer.
-					for k := 0; k < code_length; k++ {
-						skip(parser)
-					}
+			// Scan the character value.
+			if parser.unread < code_length && !ini_parser_update_buffer(parser, code_length) {
+				return false
+			}
+			for k := 0; k < code_length; k++ {
+				if !is_hex(parser.buffer, parser.buffer_pos+k) {
+					return ini_parser_set_scanner_error(parser, "while parsing a quoted scalar",
+						start_mark, "did not find expected hexdecimal number")
 				}
+				value = (value << 4) + as_hex(parser.buffer, parser.buffer_pos+k)
+			}
+
+			// Check the value and write the character.
+			if (value >= 0xD800 && value <= 0xDFFF) || value > 0x10FFFF {
+				return ini_parser_set_scanner_error(parser, "while parsing a quoted scalar",
+					start_mark, "found invalid Unicode character escape code")
+			}
+			if value <= 0x7F {
+				s = append(s, byte(value))
+			} else if value <= 0x7FF {
+				s = append(s, byte(0xC0+(value>>6)))
+				s = append(s, byte(0x80+(value&0x3F)))
+			} else if value <= 0xFFFF {
+				s = append(s, byte(0xE0+(value>>12)))
+				s = append(s, byte(0x80+((value>>6)&0x3F)))
+				s = append(s, byte(0x80+(value&0x3F)))
 			} else {
-				ini_parser_set_scanner_error(parser, "while parsing a scalar",
-					start_mark, "found unknown escape character")
+				s = append(s, byte(0xF0+(value>>18)))
+				s = append(s, byte(0x80+((value>>12)&0x3F)))
+				s = append(s, byte(0x80+((value>>6)&0x3F)))
+				s = append(s, byte(0x80+(value&0x3F)))
 			}
-			// Check if we are at the end of the scalar.
-			if parser.buffer[parser.buffer_pos] == '"' {
+
+			// Advance the pointer.
+			for k := 0; k < code_length; k++ {
 				skip(parser)
-				break
 			}
 		}
-		// Is it the end?
-		if is_break(parser.buffer, parser.buffer_pos) {
-			skip_line(parser)
-			break
-		}
 	}
+
 	// Eat the right quote.
+	skip(parser)
 	end_mark := parser.mark
 
 	// Create a token.
@@ -877,43 +1030,190 @@ func ini_parser_scan_scalar(parser *ini_parser_t, token *ini_token_t, single boo
 	return true
 }
 
-// Scan a plain scalar.
-func ini_parser_scan_plain_scalar(parser *ini_parser_t, token *ini_token_t) bool {
+// Scan a plain key, up to the '=' indicator or the end of the line.
+func ini_parser_scan_plain_key(parser *ini_parser_t, token *ini_token_t) bool {
 	start_mark := parser.mark
 	var s []byte
-	// Consume the content of the plain scalar.
 	for {
 		if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
 			return false
 		}
-		if is_break(parser.buffer, parser.buffer_pos) {
-			skip_line(parser)
+		if is_breakz(parser.buffer, parser.buffer_pos) || parser.buffer[parser.buffer_pos] == '=' {
 			break
 		}
+		s = read(parser, s)
+	}
+	*token = ini_token_t{
+		typ:        ini_SCALAR_TOKEN,
+		start_mark: start_mark,
+		end_mark:   parser.mark,
+		value:      bytes.Trim(s, " "),
+		style:      ini_PLAIN_SCALAR_STYLE,
+	}
+	return true
+}
+
+// Scan a plain value.
+//
+// A '#' or ';' following a blank starts a comment that runs to the end of
+// the line, while one at the start of the scalar is part of it.
+//
+// With continuation lines, the scalar goes on over the next line if the
+// line ends with a '\', which is dropped along with the line break and
+// the indentation of the next line. It also goes on over the following
+// lines indented deeper than its key, joined by line breaks, until a
+// blank line or a line that is not indented deeper. The comment lines
+// among them are left out. Without continuation lines, the scalar ends
+// with its line.
+func ini_parser_scan_plain_scalar(parser *ini_parser_t, token *ini_token_t) bool {
+	start_mark := parser.mark
+	end_mark := parser.mark
+	var s, line, line_comment, comments []byte
+	continued := false
+	for {
+		// Consume the content of a line.
+		for {
+			if parser.unread < 2 && !ini_parser_update_buffer(parser, 2) {
+				return false
+			}
+			if is_blank(parser.buffer, parser.buffer_pos) && (parser.buffer[parser.buffer_pos+1] == '#' || parser.buffer[parser.buffer_pos+1] == ';') {
+				if !ini_parser_scan_line_comment(parser, &line_comment) {
+					return false
+				}
+				continue
+			}
+			if is_breakz(parser.buffer, parser.buffer_pos) {
+				break
+			}
+			// Copy the character.
+			line = read(parser, line)
+		}
+		end_mark = parser.mark
+
+		// Trim blank characters.
+		line = bytes.Trim(line, " ")
+		if len(s) > 0 && !continued {
+			s = append(s, '\n')
+		}
+		continued = parser.continuation && len(line) > 0 && line[len(line)-1] == '\\'
+		if continued {
+			line = line[:len(line)-1]
+		}
+		s = append(s, line...)
+		line = line[:0]
 		if is_z(parser.buffer, parser.buffer_pos) {
 			break
 		}
-		if parser.buffer[parser.buffer_pos] == '=' {
+		if parser.unread < 2 && !ini_parser_update_buffer(parser, 2) {
+			return false
+		}
+		skip_line(parser)
+		if !parser.continuation {
 			break
 		}
-		// Copy the character.
-		s = read(parser, s)
+
+		// Look for a continuation line, past the comment lines.
+		more := false
+		for {
+			if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+				return false
+			}
+			for is_blank(parser.buffer, parser.buffer_pos) {
+				skip(parser)
+				if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+					return false
+				}
+			}
+			if is_breakz(parser.buffer, parser.buffer_pos) {
+				break
+			}
+			if parser.buffer[parser.buffer_pos] != '#' && parser.buffer[parser.buffer_pos] != ';' {
+				more = continued || parser.mark.column > parser.key_indent
+				break
+			}
+			if continued {
+				break
+			}
+			if len(comments) > 0 {
+				comments = append(comments, '\n')
+			}
+			var comment []byte
+			for !is_breakz(parser.buffer, parser.buffer_pos) {
+				comment = read(parser, comment)
+				if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+					return false
+				}
+			}
+			comments = append(comments, bytes.TrimRight(comment, " \t")...)
+			if is_z(parser.buffer, parser.buffer_pos) {
+				break
+			}
+			if parser.unread < 2 && !ini_parser_update_buffer(parser, 2) {
+				return false
+			}
+			skip_line(parser)
+		}
+		if !more {
+			break
+		}
+		comments = comments[:0]
 	}
-    end_mark := parser.mark
-    // Trim blank characters.
-    s = bytes.Trim(s, " ")
+
+	// The comment lines after the scalar belong to the next section or key.
+	if len(comments) > 0 {
+		if len(parser.head_comment) > 0 {
+			parser.head_comment = append(parser.head_comment, '\n')
+		}
+		parser.head_comment = append(parser.head_comment, comments...)
+	}
+
 	// Create a token.
 	*token = ini_token_t{
-		typ:        ini_SCALAR_TOKEN,
-		start_mark: start_mark,
-		end_mark:   end_mark,
-		value:      s,
-		style:      ini_PLAIN_SCALAR_STYLE,
+		typ:          ini_SCALAR_TOKEN,
+		start_mark:   start_mark,
+		end_mark:     end_mark,
+		value:        bytes.Trim(s, " "),
+		style:        ini_PLAIN_SCALAR_STYLE,
+		line_comment: line_comment,
 	}
 
 	return true
 }
 
+// Scan the comment that may follow a token on the same line, up to the
+// line break.
+func ini_parser_scan_line_comment(parser *ini_parser_t, comment *[]byte) bool {
+	if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+		return false
+	}
+	for is_blank(parser.buffer, parser.buffer_pos) {
+		skip(parser)
+		if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+			return false
+		}
+	}
+	if parser.buffer[parser.buffer_pos] != '#' && parser.buffer[parser.buffer_pos] != ';' {
+		return true
+	}
+	var s []byte
+	for !is_breakz(parser.buffer, parser.buffer_pos) {
+		s = read(parser, s)
+		if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
+			return false
+		}
+	}
+	*comment = bytes.TrimRight(s, " \t")
+	return true
+}
+
+// Take the comment lines collected for the next section or key, leaving
+// out the blank lines that separate them from it.
+func ini_parser_take_head_comment(parser *ini_parser_t) []byte {
+	comment := bytes.TrimRight(parser.head_comment, "\n")
+	parser.head_comment = nil
+	return comment
+}
+
 // Eat whitespaces and comments until the next token is found.
 func ini_parser_scan_to_next_token(parser *ini_parser_t) bool {
 	// Until the next token is not found.
@@ -930,6 +1230,7 @@ func ini_parser_scan_to_next_token(parser *ini_parser_t) bool {
 		if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
 			return false
 		}
+		blank_line := parser.mark.column == 0
 		for parser.buffer[parser.buffer_pos] == ' ' || parser.buffer[parser.buffer_pos] == '\t' {
 			skip(parser)
 			if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
@@ -937,18 +1238,28 @@ func ini_parser_scan_to_next_token(parser *ini_parser_t) bool {
 			}
 		}
 
-		// Eat a comment until a line break.
+		// Keep a comment for the next section or key.
 		if parser.buffer[parser.buffer_pos] == '#' || parser.buffer[parser.buffer_pos] == ';' {
+			blank_line = false
+			var s []byte
 			for !is_breakz(parser.buffer, parser.buffer_pos) {
-				skip(parser)
+				s = read(parser, s)
 				if parser.unread < 1 && !ini_parser_update_buffer(parser, 1) {
 					return false
 				}
 			}
+			if len(parser.head_comment) > 0 {
+				parser.head_comment = append(parser.head_comment, '\n')
+			}
+			parser.head_comment = append(parser.head_comment, bytes.TrimRight(s, " \t")...)
 		}
 
 		// If it is a line break, eat it.
 		if is_break(parser.buffer, parser.buffer_pos) {
+			// Keep the blank lines between comment lines.
+			if blank_line && len(parser.head_comment) > 0 {
+				parser.head_comment = append(parser.head_comment, '\n')
+			}
 			if parser.unread < 2 && !ini_parser_update_buffer(parser, 2) {
 				return false
 			}
@@ -0,0 +1,354 @@
+package ini
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Violation is a key or section breaking a constraint, either of the
+// ini tag of the struct field it is decoded into, or of the rules given
+// to Section.Validate or Key.Validate.
+type Violation struct {
+	// Section is the name of the section holding the key.
+	Section string
+
+	// Key is the full dotted name of the key, or is empty when the
+	// whole section breaks the constraint.
+	Key string
+
+	// Line is the line of the key, or of its section if the key is
+	// missing, or zero if it is unknown.
+	Line int
+
+	// Message tells the constraint broken, as in "must be at most 10".
+	Message string
+}
+
+func (v Violation) String() string {
+	var where string
+	if v.Key == "" {
+		where = fmt.Sprintf("section '%s' %s", v.Section, v.Message)
+	} else {
+		where = fmt.Sprintf("key '%s' in section '%s' %s", v.Key, v.Section, v.Message)
+	}
+	if v.Line == 0 {
+		return where
+	}
+	return fmt.Sprintf("line %d: %s", v.Line, where)
+}
+
+// A ValidationError is returned by Unmarshal when values of the INI
+// document break the constraints given by the flags of the ini tags of
+// the struct fields they are decoded into, as in `ini:"port,required,
+// min=1,max=65535"`. The flags are required, min, max, oneof and regexp.
+// It holds every violation found, in the order of their lines. When this
+// error is returned, the value is still unmarshaled entirely.
+type ValidationError struct {
+	Violations []Violation
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		lines[i] = v.String()
+	}
+	return fmt.Sprintf("ini: validation errors:\n  %s", strings.Join(lines, "\n  "))
+}
+
+// constraints are the flags of an ini tag, or the rules given to
+// Section.Validate and Key.Validate, that a value is checked against:
+//
+//	required      a key must set the value
+//	min=N, max=N  bounds of a number, or of the length of a string,
+//	              slice or map
+//	oneof=a|b|c   values allowed
+//	regexp=RE     pattern matched by the value written as a string
+//
+// The bounds and values allowed are decoded as the value itself is, so
+// min=1s bounds a time.Duration. A slice must have each of its items
+// allowed or matching. As the flags are separated by commas, a pattern
+// cannot hold any.
+type constraints struct {
+	Required bool
+	Min, Max *string
+	OneOf    []string
+	Regexp   *regexp.Regexp
+}
+
+// add adds the constraint written as flag to c, and reports whether flag
+// is one.
+func (c *constraints) add(flag string) (bool, error) {
+	if flag == "required" {
+		c.Required = true
+		return true, nil
+	}
+	i := strings.Index(flag, "=")
+	if i < 0 {
+		return false, nil
+	}
+	arg := flag[i+1:]
+	switch flag[:i] {
+	case "min":
+		c.Min = &arg
+	case "max":
+		c.Max = &arg
+	case "oneof":
+		c.OneOf = strings.Split(arg, "|")
+	case "regexp":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return false, err
+		}
+		c.Regexp = re
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// parseConstraints parses the comma separated rules of Section.Validate
+// and Key.Validate.
+func parseConstraints(rules string) *constraints {
+	c := &constraints{}
+	for _, flag := range strings.Split(rules, ",") {
+		ok, err := c.add(flag)
+		if err != nil {
+			failf("invalid rule %q: %v", flag, err)
+		}
+		if !ok {
+			failf("unsupported rule %q", flag)
+		}
+	}
+	return c
+}
+
+// check returns the message of the first constraint out breaks, or ""
+// if it keeps them all. The required constraint is up to the caller.
+func (c *constraints) check(out reflect.Value) string {
+	out = indirect(out)
+	if !out.IsValid() {
+		return ""
+	}
+	if c.Min != nil {
+		if cmp, length := compareBound(out, *c.Min); cmp < 0 && length {
+			return "must have a length of at least " + *c.Min
+		} else if cmp < 0 {
+			return "must be at least " + *c.Min
+		}
+	}
+	if c.Max != nil {
+		if cmp, length := compareBound(out, *c.Max); cmp > 0 && length {
+			return "must have a length of at most " + *c.Max
+		} else if cmp > 0 {
+			return "must be at most " + *c.Max
+		}
+	}
+	if out.Kind() == reflect.Slice || out.Kind() == reflect.Array {
+		for i := 0; i < out.Len(); i++ {
+			if msg := c.checkItem(out.Index(i)); msg != "" {
+				return msg
+			}
+		}
+		return ""
+	}
+	return c.checkItem(out)
+}
+
+// checkItem checks a single value against the oneof and regexp
+// constraints.
+func (c *constraints) checkItem(out reflect.Value) string {
+	out = indirect(out)
+	if !out.IsValid() {
+		return ""
+	}
+	if c.OneOf != nil && !oneOf(out, c.OneOf) {
+		return "must be one of " + strings.Join(c.OneOf, "|")
+	}
+	if c.Regexp != nil {
+		s, ok := out.Interface().(string)
+		if !ok {
+			s = fmt.Sprint(out.Interface())
+		}
+		if !c.Regexp.MatchString(s) {
+			return "must match " + c.Regexp.String()
+		}
+	}
+	return ""
+}
+
+// compareBound compares out with bound, which is decoded as out is, or
+// is a length if out is a string, slice or map. It returns -1, 0 or 1,
+// and whether it compared lengths.
+func compareBound(out reflect.Value, bound string) (cmp int, length bool) {
+	switch out.Kind() {
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		n, err := strconv.Atoi(bound)
+		if err != nil {
+			failf("invalid length bound %q for %s", bound, out.Type())
+		}
+		l := out.Len()
+		if out.Kind() == reflect.String {
+			l = utf8.RuneCountInString(out.String())
+		}
+		return compareInts(int64(l), int64(n)), true
+	}
+	b := reflect.New(out.Type()).Elem()
+	if !decodeText(bound, b) {
+		failf("invalid bound %q for %s", bound, out.Type())
+	}
+	switch out.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return compareInts(out.Int(), b.Int()), false
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		switch {
+		case out.Uint() < b.Uint():
+			return -1, false
+		case out.Uint() > b.Uint():
+			return 1, false
+		}
+		return 0, false
+	case reflect.Float32, reflect.Float64:
+		switch {
+		case out.Float() < b.Float():
+			return -1, false
+		case out.Float() > b.Float():
+			return 1, false
+		}
+		return 0, false
+	}
+	failf("cannot bound a value of type %s", out.Type())
+	return 0, false
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// oneOf reports whether out equals one of the values, decoded as out is.
+func oneOf(out reflect.Value, values []string) bool {
+	for _, value := range values {
+		v := reflect.New(out.Type()).Elem()
+		if decodeText(value, v) && reflect.DeepEqual(v.Interface(), out.Interface()) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeText unmarshals text into out as a plain value, and reports
+// whether it could.
+func decodeText(text string, out reflect.Value) bool {
+	d := newDecoder()
+	d.unmarshal(&node{kind: scalarNode, value: text}, out)
+	return len(d.terrors) == 0
+}
+
+// indirect returns the value out points to or holds, or an invalid value
+// if it is nil.
+func indirect(out reflect.Value) reflect.Value {
+	for out.Kind() == reflect.Ptr || out.Kind() == reflect.Interface {
+		if out.IsNil() {
+			return reflect.Value{}
+		}
+		out = out.Elem()
+	}
+	return out
+}
+
+// check is a decoded struct field with constraints, checked once the
+// whole document is decoded.
+type check struct {
+	constraints *constraints
+	out         reflect.Value
+	violation   Violation
+}
+
+// constrain arranges for the field decoded from the key at line to be
+// checked against the constraints of info.
+func (d *decoder) constrain(info fieldInfo, field reflect.Value, section, key string, line int) {
+	if info.Constraints == nil {
+		return
+	}
+	d.checks = append(d.checks, check{info.Constraints, field, Violation{Section: section, Key: key, Line: line}})
+}
+
+// require reports the field of info that no key was decoded into if it
+// is required.
+func (d *decoder) require(info fieldInfo, section, key string, line int) {
+	if info.Constraints != nil && info.Constraints.Required {
+		d.violations = append(d.violations, Violation{section, key, line, "is required"})
+	}
+}
+
+// validate checks the decoded fields against their constraints, and
+// returns every violation found, in the order of their lines.
+func (d *decoder) validate() []Violation {
+	violations := d.violations
+	for _, check := range d.checks {
+		if msg := check.constraints.check(check.out); msg != "" {
+			check.violation.Message = msg
+			violations = append(violations, check.violation)
+		}
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Line < violations[j].Line
+	})
+	return violations
+}
+
+// Validate checks the keys of the section against rules, which map the
+// name of a key to constraints written as the flags of an ini tag, as in
+// "required,min=1,max=65535". It returns a ValidationError holding every
+// violation found. Without a struct field to decode into, a value is
+// checked as Unmarshal would decode it into an interface{}.
+func (s *Section) Validate(rules map[string]string) (err error) {
+	defer handleErr(&err)
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	d := newDecoder()
+	for _, name := range names {
+		c := parseConstraints(rules[name])
+		if k := s.Key(name); k != nil {
+			k.check(d, c)
+		} else if c.Required {
+			d.violations = append(d.violations, Violation{s.Name(), name, s.name.line + 1, "is required"})
+		}
+	}
+	if violations := d.validate(); len(violations) > 0 {
+		return &ValidationError{violations}
+	}
+	return nil
+}
+
+// Validate checks the value of the key against rules, written the same
+// way as for Section.Validate.
+func (k *Key) Validate(rules string) (err error) {
+	defer handleErr(&err)
+	d := newDecoder()
+	k.check(d, parseConstraints(rules))
+	if violations := d.validate(); len(violations) > 0 {
+		return &ValidationError{violations}
+	}
+	return nil
+}
+
+// check arranges for d to check the value of the key against c.
+func (k *Key) check(d *decoder, c *constraints) {
+	var value interface{}
+	d.unmarshal(k.node, reflect.ValueOf(&value).Elem())
+	d.checks = append(d.checks, check{c, reflect.ValueOf(value), Violation{Section: k.section.Name(), Key: k.name, Line: k.key.line + 1}})
+}